@@ -35,6 +35,8 @@ func setConfig() {
 	viper.SetDefault("cdcFile", "/cdc/cdc.log")
 	viper.SetDefault("activityLogFile", "/cdc/activity.log")
 	viper.SetDefault("changeLogFile", "/cdc/change.log")
+
+	viper.SetDefault("eslPassword", "ClueCon")
 }
 
 func validateConfig() {
@@ -66,6 +68,12 @@ func main() {
 		TailChange:     viper.GetString("cdcFile"),
 		AppendActivity: viper.GetString("activityLogFile"),
 		AppendChange:   viper.GetString("changeLogFile"),
+		RegionMobile:   viper.GetString("regionMobileFile"),
+	}
+
+	eslConf := &server.EslConf{
+		Addr:     viper.GetString("eslAddr"),
+		Password: viper.GetString("eslPassword"),
 	}
 
 	app := pocketbase.New()
@@ -74,7 +82,7 @@ func main() {
 	migratecmd.MustRegister(app, app.RootCmd, migratecmd.Config{Automigrate: isGoRun, Dir: "./sql/app"})
 
 	// color.NoColor = true
-	server.MustRegister(app, pathConf)
+	server.MustRegister(app, pathConf, eslConf)
 
 	if err := app.Start(); err != nil {
 		log.Fatal(err)
@@ -20,6 +20,34 @@ export const SchemaOutGwTrans = yup.object({
           yup.string().label('替换内容').trim()
         ])
     })
+    .when('type', {
+      is: 'strip',
+      then: () => yup.tuple([yup.string().label('前缀').required().trim()])
+    })
+    .when('type', {
+      is: 'truncate',
+      then: () =>
+        yup.tuple([
+          yup.string().label('位数').required().matches(/^[1-9][0-9]*$/),
+          yup
+            .string()
+            .label('保留')
+            .matches(/^(head|tail)?$/)
+        ])
+    })
+    .when('type', {
+      is: 'pad',
+      then: () =>
+        yup.tuple([
+          yup.string().label('位数').required().matches(/^[1-9][0-9]*$/),
+          yup.string().label('填充字符').max(1)
+        ])
+    }),
+  table: yup.object().when('type', {
+    is: 'lookup',
+    then: (s) =>
+      s.test('table', '对照表不能为空', (v) => v != null && Object.keys(v).length > 0)
+  })
 })
 
 export const SchemaOutGw = yup.object({
@@ -28,9 +56,17 @@ export const SchemaOutGw = yup.object({
   addr: yup.string().label('地址').trim().required(),
   enable: yup.boolean().label('启用').required(),
   options: yup.object({
+    username: yup.string().label('用户名').trim(),
     password: yup.string().label('密码'),
-    registry: yup.boolean().label('是否登陆')
+    registry: yup.boolean().label('是否登陆'),
+    profile: yup
+      .string()
+      .label('Profile')
+      .trim()
+      .matches(/^[0-9A-Za-z_\-.]*$/),
+    e164Callee: yup.boolean().label('被叫从E.164变换')
   }),
+  dialplan: yup.string().label('拨号模板').max(20000),
   transcaller: yup.array().of(SchemaOutGwTrans),
   transcallee: yup.array().of(SchemaOutGwTrans)
 })
@@ -55,7 +91,12 @@ export const SchemaObjective = yup.object({
 
 export const SchemaConfigDial = yup.object({
   caller: yup.object({
-    affinity: yup.boolean().label('亲和性呼叫')
+    affinity: yup.boolean().label('亲和性呼叫'),
+    strategy: yup.string().label('选号策略').oneOf(['random', 'roundrobin', 'lru', 'connectrate', 'affinity'])
+  }),
+  failover: yup.object({
+    attempts: yup.number().label('最多尝试次数').integer().min(1).max(5),
+    causes: yup.array().of(yup.string().trim()).label('切换原因')
   })
 })
 
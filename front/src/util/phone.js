@@ -13,6 +13,7 @@ var Phone = {
     _session: null
   },
   bind: false,
+  credTimer: null,
   init: function () {
     if (this.ua !== null) {
       return
@@ -34,11 +35,10 @@ var Phone = {
 
     const configuration = {
       sockets: [socket],
-      register: false,
+      register: true,
+      register_expires: 300,
       uri: uri
-      //register_expires: 5,
       //extra_headers: ['Foo: ABC', 'Bar: XYZ'],
-      //password: '1234'
     }
     this.ua = new JsSIP.UA(configuration)
   },
@@ -70,9 +70,28 @@ var Phone = {
 
         this.bind = true
       }
-      this.ua.start()
+      this.credential()
+        .catch((err) => console.error('failed to fetch sip credential', err))
+        .finally(() => this.ua?.start())
     })
   },
+  // the sip password is short-lived, fetch the current one and refresh it when expires.
+  // the server still accepts the expired one for a window, challenges before the refresh pass
+  credential: async function () {
+    const cred = await pb.send('/api/custom/call/sip/credential', {})
+    if (this.ua === null) {
+      return
+    }
+    this.ua.set('password', cred.password)
+
+    clearTimeout(this.credTimer)
+    const ms = Math.max(new Date(cred.expires) - Date.now(), 0) + 1000
+    this.credTimer = setTimeout(() => {
+      this.credential()
+        .then(() => this.ua?.register())
+        .catch((err) => console.error('failed to refresh sip credential', err))
+    }, ms)
+  },
   stop: function () {
     this.hangup()
 
@@ -80,6 +99,8 @@ var Phone = {
       this.ua.stop()
       this.ua.removeAllListeners()
     }
+    clearTimeout(this.credTimer)
+    this.credTimer = null
     this.ua = null
     this.bind = false
     this.status.value = ''
@@ -0,0 +1,70 @@
+package call
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+
+	_ "github.com/tcmzzz/lightcall/sql/app"
+)
+
+// newTestApp is an app of a temporary data dir with all the migrations applied.
+func newTestApp(t *testing.T) core.App {
+	t.Helper()
+
+	app := core.NewBaseApp(core.BaseAppConfig{DataDir: t.TempDir()})
+	if err := app.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.RunAllMigrations(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = app.ResetBootstrapState() })
+	return app
+}
+
+// saveRecord saves a record of the collection with the data.
+func saveRecord(t *testing.T, app core.App, collection string, data map[string]any) *core.Record {
+	t.Helper()
+
+	c, err := app.FindCollectionByNameOrId(collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := core.NewRecord(c)
+	r.Load(data)
+	if err := app.Save(r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// saveConfig saves the config of the name, value is marshaled as json.
+func saveConfig(t *testing.T, app core.App, name string, value any) {
+	t.Helper()
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveRecord(t, app, "config", map[string]any{"name": name, "value": string(b)})
+}
+
+// saveNumber saves an enabled number on a new enabled outgw of the address.
+func saveNumber(t *testing.T, app core.App, number, addr string) *core.Record {
+	t.Helper()
+
+	gw := saveRecord(t, app, "outgw", map[string]any{"name": addr, "protocal": "SIP", "addr": addr, "enable": true})
+	return saveRecord(t, app, "number", map[string]any{"number": number, "outgw": gw.Id, "enable": true})
+}
+
+// saveTask saves an open task of the user calling the callee.
+func saveTask(t *testing.T, app core.App, user *core.Record, callee string) *core.Record {
+	t.Helper()
+
+	return saveRecord(t, app, "task", map[string]any{
+		"own": user.Id, "contact": "c", "callee": callee, "desc": "d", "open": true, "ext_id": security.PseudorandomString(15),
+	})
+}
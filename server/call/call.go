@@ -1,10 +1,9 @@
 package call
 
 import (
-	"math/rand"
-	"time"
+	"github.com/tcmzzz/lightcall/server/config"
 
-	"github.com/pkg/errors"
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
 )
 
@@ -14,58 +13,236 @@ type Result struct {
 	Caller    string
 	Callee    string
 	Addr      string
+	Gateway   string
 }
 
-func makeCall(app core.App, user *core.Record, taskID string) (*Result, error) {
+// Plan is how a call would be placed: the chosen caller, or nil with Error set,
+// the failover attempts in dialing order starting with the chosen caller,
+// and every number left out of the selection.
+type Plan struct {
+	Result   *Result     `json:"result"`
+	Attempts []*Result   `json:"attempts"`
+	Rejected []Rejection `json:"rejected"`
+	Error    string      `json:"error,omitempty"`
+	Code     string      `json:"code,omitempty"` // code of the Failure when Error is set
+}
 
-	task, err := app.FindRecordById("task", taskID)
+// Rejection is a caller number left out of the selection and why.
+type Rejection struct {
+	Number  string `json:"number"`
+	Gateway string `json:"gateway"`
+	Reason  string `json:"reason"`
+}
+
+func reject(c *core.Record, reason string) Rejection {
+	return Rejection{Number: c.GetString("number"), Gateway: c.GetString("outgw"), Reason: reason}
+}
+
+// makeCall prepares the call of the task by the user, returning the attempts
+// in dialing order. The first attempt is the chosen caller, the others are failover.
+// activityID is the activity being dialed, empty when the activity is not created yet.
+func makeCall(app core.App, conf config.Provider, user *core.Record, taskID, activityID string) ([]*Result, error) {
+	plan, err := planCall(app, conf, user, taskID, activityID, false)
 	if err != nil {
 		return nil, err
 	}
+	return plan.Attempts, nil
+}
 
-	// check userId. Admin or Owner
-	own := task.GetString("own")
-	isAdmin := user.GetBool("isAdmin")
+// planCall runs the whole call preparation, nothing is saved.
+// With peek the ordered strategies are not moved on, the plan shows the caller the next call would use.
+// The returned plan is never nil, and keeps the rejections made before a failure.
+func planCall(app core.App, conf config.Provider, user *core.Record, taskID, activityID string, peek bool) (*Plan, error) {
+
+	plan := &Plan{Attempts: []*Result{}, Rejected: []Rejection{}}
+
+	task, err := app.FindRecordById("task", taskID)
+	if err != nil {
+		return plan, failed(ErrTaskNotFound, err)
+	}
 
-	if task.GetString("own") != user.Id && !isAdmin {
-		return nil, errors.Errorf("not allow to call(task owner: %s, user: %s, isAdmin: %v)", own, user.Id, isAdmin)
+	if err := checkOwner(task, user); err != nil {
+		return plan, err
 	}
 
 	callee := task.GetString("callee")
 
+	// objective of the task, if any, restricts the caller numbers
+	objective := objectiveOf(app, task.Id)
+
+	// refuse calls outside calling hours
+	if err := checkHours(app, conf, objective); err != nil {
+		return plan, err
+	}
+
 	// find caller
-	r, err := FindCaller(app, callee)
+	records, rejected, err := FindCaller(app, conf, &CallerQuery{
+		Callee:     callee,
+		ActivityID: activityID,
+		Objective:  objective,
+		User:       user,
+		Peek:       peek,
+	})
+	plan.Rejected = append(plan.Rejected, rejected...)
+	if err != nil {
+		return plan, err
+	}
+
+	// a failover attempt that can not be built is left out, the chosen one can not
+	for i, r := range records {
+		result, err := buildResult(app, r, task)
+		if err != nil && i == 0 {
+			return plan, err
+		}
+		if err != nil {
+			app.Logger().Warn("leave out failover caller", "number", r.Id, "err", err)
+			plan.Rejected = append(plan.Rejected, reject(r, err.Error()))
+			continue
+		}
+		plan.Attempts = append(plan.Attempts, result)
+	}
+	plan.Result = plan.Attempts[0]
+	return plan, nil
+}
+
+// replanCall checks the attempts kept on the activity again before FreeSWITCH dials them,
+// as planCall would choose them now: the calling hours, and each number enabled with its outgw,
+// allowed by the pools, under the call caps and not excluded by its marks. rawlog only tells
+// which numbers were chosen, the attempts are built again from their records.
+// The call is prepared again by makeCall when none was kept or the chosen number is no longer usable.
+func replanCall(app core.App, conf config.Provider, user *core.Record, taskID string, activity *core.Record) ([]*Result, error) {
+
+	kept := plannedAttempts(activity)
+	if len(kept) == 0 {
+		return makeCall(app, conf, user, taskID, activity.Id)
+	}
+
+	task, err := app.FindRecordById("task", taskID)
 	if err != nil {
+		return nil, failed(ErrTaskNotFound, err)
+	}
+	if err := checkOwner(task, user); err != nil {
+		return nil, err
+	}
+
+	objective := objectiveOf(app, task.Id)
+	if err := checkHours(app, conf, objective); err != nil {
 		return nil, err
 	}
+
+	dial, err := conf.Dial()
+	if err != nil {
+		app.Logger().Warn("failed to load dial config, use default strategy", "err", err)
+		dial = &config.Dial{}
+	}
+
+	records := make([]*core.Record, 0, len(kept))
+	for _, r := range kept {
+		number, err := app.FindFirstRecordByFilter("number", "number = {:number} && outgw = {:outgw}",
+			dbx.Params{"number": r.OriCaller, "outgw": r.Gateway})
+		if err != nil {
+			app.Logger().Warn("kept caller not found", "activity", activity.Id, "number", r.OriCaller, "outgw", r.Gateway)
+			continue
+		}
+		records = append(records, number)
+	}
+
+	candidates, _, err := usableCallers(app, dial, &CallerQuery{
+		ActivityID: activity.Id,
+		Objective:  objective,
+		User:       user,
+	}, records)
+	if err != nil || candidates[0].Number() != kept[0].OriCaller || candidates[0].Record.GetString("outgw") != kept[0].Gateway {
+		app.Logger().Warn("kept caller no longer usable, choose again", "activity", activity.Id, "number", kept[0].OriCaller, "err", err)
+		return makeCall(app, conf, user, taskID, activity.Id)
+	}
+
+	// a failover attempt that can not be built is left out, the chosen one can not
+	results := make([]*Result, 0, len(candidates))
+	for i, c := range candidates {
+		result, err := buildResult(app, c.Record, task)
+		if err != nil && i == 0 {
+			return nil, err
+		}
+		if err != nil {
+			app.Logger().Warn("leave out failover caller", "number", c.Record.Id, "err", err)
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// checkOwner fails with ErrNotOwner unless the user owns the task or is an admin.
+func checkOwner(task *core.Record, user *core.Record) error {
+	own := task.GetString("own")
+	isAdmin := user.GetBool("isAdmin")
+
+	if own != user.Id && !isAdmin {
+		return failf(ErrNotOwner, "task owner: %s, user: %s, isAdmin: %v", own, user.Id, isAdmin)
+	}
+	return nil
+}
+
+// objectiveOf returns the objective the task belongs to, nil when none.
+func objectiveOf(app core.App, taskID string) *core.Record {
+	objective, err := app.FindFirstRecordByFilter("objective", "tasks.id ?= {:task}", dbx.Params{"task": taskID})
+	if err != nil {
+		return nil
+	}
+	return objective
+}
+
+// outgwOptions is the options json of an outgw.
+type outgwOptions struct {
+	Username   string `json:"username"` // 注册/鉴权用户名
+	Password   string `json:"password"`
+	Registry   bool   `json:"registry"`   // 是否向线路商注册
+	Profile    string `json:"profile"`    // 网关所在的 sofia profile, 默认 external
+	E164Callee bool   `json:"e164Callee"` // transcallee 从 E.164 形式的被叫开始变换
+}
+
+// buildResult transforms the caller number and the callee of the task by the rules of the number's outgw.
+// A transform failing or leaving an empty number fails with ErrTransFailed.
+func buildResult(app core.App, r *core.Record, task *core.Record) (*Result, error) {
 	caller := r.GetString("number")
+	callee := task.GetString("callee")
 
 	gw := r.ExpandedOne("outgw")
 	if gw == nil || gw.Id == "" {
-		return nil, errors.Errorf("number have no related outgw: %s", caller)
+		return nil, failf(ErrGatewayDisabled, "number have no related outgw: %s", caller)
+	}
+
+	opts := outgwOptions{}
+	if err := gw.UnmarshalJSONField("options", &opts); err != nil && gw.GetString("options") != "" {
+		app.Logger().Warn("failed to parse outgw options", "outgw", gw.Id, "err", err)
+	}
+
+	// invalid numbers have no canonical form, they are transformed as is
+	from := callee
+	if opts.E164Callee && task.GetBool("calleeValid") {
+		from = task.GetString("calleeE164")
 	}
 
 	// Transform caller and callee
-	transCallee := make([]TransItem, 0)
-	transCaller := make([]TransItem, 0)
+	transCaller, transCallee := transOf(app, gw)
 
 	addr := gw.GetString("addr")
 
-	if err := gw.UnmarshalJSONField("transcaller", &transCaller); err != nil {
-		app.Logger().Error("failed to parse transcaller", "err", err)
-	}
-	if err := gw.UnmarshalJSONField("transcallee", &transCallee); err != nil {
-		app.Logger().Error("failed to parse transcallee", "err", err)
-	}
-
 	tCaller, err := ApplyTrans(caller, transCaller)
 	if err != nil {
-		app.Logger().Error("failed to trans transcaller", "err", err)
+		return nil, failf(ErrTransFailed, "transcaller of %s: %v", gw.Id, err)
+	}
+	if tCaller == "" {
+		return nil, failf(ErrTransFailed, "transcaller of %s: empty caller from %s", gw.Id, caller)
 	}
 
-	tCallee, err := ApplyTrans(callee, transCallee)
+	tCallee, err := ApplyTrans(from, transCallee)
 	if err != nil {
-		app.Logger().Error("failed to trans transcallee", "err", err)
+		return nil, failf(ErrTransFailed, "transcallee of %s: %v", gw.Id, err)
+	}
+	if tCallee == "" {
+		return nil, failf(ErrTransFailed, "transcallee of %s: empty callee from %s", gw.Id, from)
 	}
 
 	return &Result{
@@ -74,43 +251,138 @@ func makeCall(app core.App, user *core.Record, taskID string) (*Result, error) {
 		Caller:    tCaller,
 		Callee:    tCallee,
 		Addr:      addr,
+		Gateway:   gw.Id,
 	}, nil
 }
 
-// TODO: system caller strategy
-func FindCaller(app core.App, callee string) (*core.Record, error) {
+// CallerQuery describes the call FindCaller picks a caller number for.
+type CallerQuery struct {
+	Callee     string
+	ActivityID string       // activity being dialed, not counted against call caps
+	Objective  *core.Record // objective of the task, its number pool applies if set
+	User       *core.Record // agent placing the call, its number pool applies if set
+	Peek       bool         // only planned, the strategy is not moved on
+}
+
+// FindCaller picks a caller number for the callee among the enabled numbers
+// whose outgw is also enabled, narrowed to the objective and user pools,
+// filtered by call caps and spam marks, and chosen by the strategy configured in dial.
+// With dial failover enabled, numbers on other gateways follow the chosen one
+// in the order the strategy picks them, at most one per gateway. They are picked
+// on a peeked copy, so round-robin and LRU move on by the chosen caller only.
+// The numbers left out are returned with their reason, even on failure.
+func FindCaller(app core.App, conf config.Provider, q *CallerQuery) ([]*core.Record, []Rejection, error) {
+
+	dial, err := conf.Dial()
+	if err != nil {
+		app.Logger().Warn("failed to load dial config, use default strategy", "err", err)
+		dial = &config.Dial{}
+	}
 
 	records, err := app.FindAllRecords("number")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	enabledRecords := make([]*core.Record, 0)
-	for _, record := range records {
-		if !record.GetBool("enable") {
-			continue
-		}
+	candidates, rejected, err := usableCallers(app, dial, q, records)
+	if err != nil {
+		return nil, rejected, err
+	}
 
-		errs := app.ExpandRecord(record, []string{"outgw"}, nil)
-		if len(errs) > 0 {
-			app.Logger().Warn("failed to expand gateway for number", "number", record.Id, "errors", errs)
-			continue
+	strategy := newCallerStrategy(dial, q.Peek)
+	picked := make([]*core.Record, 0)
+	for len(picked) < maxAttempts(dial.Failover) && len(candidates) > 0 {
+		c, err := strategy.Pick(app, q.Callee, candidates)
+		if err != nil {
+			if len(picked) > 0 {
+				app.Logger().Warn("failed to pick failover caller", "err", err)
+				break
+			}
+			return nil, rejected, err
+		}
+		picked = append(picked, c.Record)
+		candidates = otherGateways(candidates, c.Record.GetString("outgw"))
+		// only the chosen caller moves the ordered strategies on, the failover ones are picked on a copy
+		if len(picked) == 1 {
+			strategy = newCallerStrategy(dial, true)
 		}
+	}
+	return picked, rejected, nil
+}
+
+// usableCallers keeps the numbers enabled with their outgw, allowed by the objective and user pools,
+// under the call caps and not excluded by their marks, in the order of records.
+// The numbers left out are returned with their reason, even on failure.
+func usableCallers(app core.App, dial *config.Dial, q *CallerQuery, records []*core.Record) ([]*Candidate, []Rejection, error) {
 
-		gw := record.ExpandedOne("outgw")
-		if gw == nil || !gw.GetBool("enable") {
+	candidates := make([]*Candidate, 0)
+	rejected := make([]Rejection, 0)
+	for _, record := range records {
+		if reason := checkCaller(app, record); reason != "" {
+			rejected = append(rejected, reject(record, reason))
 			continue
 		}
+		candidates = append(candidates, &Candidate{Record: record, Weight: 1})
+	}
 
-		enabledRecords = append(enabledRecords, record)
+	if len(candidates) == 0 {
+		return nil, rejected, noCaller(rejected)
 	}
 
-	if len(enabledRecords) == 0 {
-		return nil, errors.New("no enabled numbers with enabled gateways available")
+	candidates, r, err := applyPools(candidates, poolOf(q.Objective), poolOf(q.User))
+	rejected = append(rejected, r...)
+	if err != nil {
+		return nil, rejected, err
 	}
 
-	rd := rand.New(rand.NewSource(time.Now().UnixNano()))
-	ret := enabledRecords[rd.Intn(len(enabledRecords))]
+	candidates, r, err = applyCaps(app, q.ActivityID, candidates)
+	rejected = append(rejected, r...)
+	if err != nil {
+		return nil, rejected, err
+	}
 
-	return ret, nil
+	candidates, r, err = applyMarkFilter(dial.Caller.Mark, candidates)
+	rejected = append(rejected, r...)
+	if err != nil {
+		return nil, rejected, err
+	}
+	return candidates, rejected, nil
+}
+
+// noCaller is the error when no number is enabled with its gateway,
+// ErrGatewayDisabled when some numbers are enabled but none of their gateways.
+func noCaller(rejected []Rejection) error {
+	enabled := 0
+	for _, r := range rejected {
+		if r.Reason != "number disabled" {
+			enabled++
+		}
+	}
+	if enabled > 0 {
+		return failf(ErrGatewayDisabled, "the gateways of all %d enabled numbers are disabled or missing", enabled)
+	}
+	return failf(ErrNoCaller, "no enabled numbers with enabled gateways available")
+}
+
+// checkCaller returns why the number can not be used, or "" when the number
+// and its outgw are both enabled. The outgw relation is expanded on success.
+func checkCaller(app core.App, record *core.Record) string {
+	if !record.GetBool("enable") {
+		return "number disabled"
+	}
+
+	errs := app.ExpandRecord(record, []string{"outgw"}, nil)
+	if len(errs) > 0 {
+		app.Logger().Warn("failed to expand gateway for number", "number", record.Id, "errors", errs)
+		return "failed to expand gateway"
+	}
+
+	gw := record.ExpandedOne("outgw")
+	if gw == nil {
+		return "no gateway"
+	}
+	if !gw.GetBool("enable") {
+		return "gateway disabled"
+	}
+	return ""
 }
@@ -0,0 +1,138 @@
+package call
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tcmzzz/lightcall/server/callstate"
+
+	"github.com/pkg/errors"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// activeCallTimeout is how long a call activity without CDR is counted as in progress.
+const activeCallTimeout = 2 * time.Hour
+
+// callCaps are the limits set on a number or outgw record, 0 means unlimited.
+type callCaps struct {
+	MaxConcurrent int
+	MaxPerHour    int
+	MaxPerDay     int
+}
+
+func capsOf(r *core.Record) callCaps {
+	return callCaps{
+		MaxConcurrent: r.GetInt("maxConcurrent"),
+		MaxPerHour:    r.GetInt("maxPerHour"),
+		MaxPerDay:     r.GetInt("maxPerDay"),
+	}
+}
+
+// reached returns the first limit reached by the calls whose rawlog.call.<key> is value,
+// or "" if none is. The activity being dialed is not counted.
+func (c callCaps) reached(app core.App, key, value, activityID string) (string, error) {
+
+	limits := []struct {
+		name   string
+		max    int
+		window time.Duration
+		active bool
+	}{
+		{"maxConcurrent", c.MaxConcurrent, activeCallTimeout, true},
+		{"maxPerHour", c.MaxPerHour, time.Hour, false},
+		{"maxPerDay", c.MaxPerDay, 24 * time.Hour, false},
+	}
+
+	for _, l := range limits {
+		if l.max <= 0 {
+			continue
+		}
+		n, err := countCalls(app, key, value, activityID, l.window, l.active)
+		if err != nil {
+			return "", err
+		}
+		if n >= int64(l.max) {
+			return fmt.Sprintf("%s reached(%d/%d)", l.name, n, l.max), nil
+		}
+	}
+	return "", nil
+}
+
+// countCalls counts the call activities of the key within the window. rawlog.call is the attempt
+// dialed first, and the one actually bridged once the CDR arrives.
+// Calls still waiting for their CDR are counted by creation time, finished ones by start time,
+// the calls that failed before dialing or were abandoned are not counted.
+// With activeOnly only the calls in progress are counted, those ended by ESL are not.
+func countCalls(app core.App, key, value, activityID string, window time.Duration, activeOnly bool) (int64, error) {
+
+	params := dbx.Params{
+		"value":     value,
+		"activity":  activityID,
+		"since":     types.NowDateTime().Add(-window).String(),
+		"epoch":     time.Now().Add(-window).Unix(),
+		"abandoned": callstate.Abandoned,
+		"ended":     callstate.Ended,
+		"failed":    callstate.Failed,
+	}
+
+	cond := "json_extract([[rawlog]], '$.fslega') IS NULL AND json_extract([[rawlog]], '$.fail') IS NULL" +
+		" AND [[created]] >= {:since} AND [[state]] != {:abandoned}"
+	if activeOnly {
+		cond += " AND [[state]] NOT IN ({:ended}, {:failed})"
+	} else {
+		cond = "json_extract([[rawlog]], '$.state.start_epoch') >= {:epoch} OR (" + cond + ")"
+	}
+
+	n, err := app.CountRecords(
+		"activity",
+		dbx.NewExp("[[isCall]] = TRUE AND [[id]] != {:activity}", params),
+		dbx.NewExp("json_extract([[rawlog]], '$.call."+key+"') = {:value}", params),
+		dbx.NewExp(cond, params),
+	)
+	if err != nil {
+		return 0, errors.Wrapf(err, "count calls fail(%s: %s)", key, value)
+	}
+	return n, nil
+}
+
+// applyCaps drops the candidates whose number or outgw reached a call cap.
+func applyCaps(app core.App, activityID string, candidates []*Candidate) ([]*Candidate, []Rejection, error) {
+
+	gwReached := map[string]string{}
+
+	ret := make([]*Candidate, 0, len(candidates))
+	rejected := make([]Rejection, 0)
+	for _, c := range candidates {
+
+		reason, err := capsOf(c.Record).reached(app, "OriCaller", c.Number(), activityID)
+		if err != nil {
+			return nil, rejected, err
+		}
+		if reason != "" {
+			rejected = append(rejected, reject(c.Record, "number "+reason))
+			continue
+		}
+
+		gw := c.Record.ExpandedOne("outgw")
+		gwReason, ok := gwReached[gw.Id]
+		if !ok {
+			if gwReason, err = capsOf(gw).reached(app, "Gateway", gw.Id, activityID); err != nil {
+				return nil, rejected, err
+			}
+			gwReached[gw.Id] = gwReason
+		}
+		if gwReason != "" {
+			rejected = append(rejected, reject(c.Record, "gateway "+gwReason))
+			continue
+		}
+
+		ret = append(ret, c)
+	}
+
+	if len(ret) == 0 {
+		return nil, rejected, failf(ErrCapsReached, "all %d enabled numbers or their gateways reached call caps", len(candidates))
+	}
+	return ret, rejected, nil
+}
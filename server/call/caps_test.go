@@ -0,0 +1,78 @@
+package call
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tcmzzz/lightcall/server/callstate"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountCalls(t *testing.T) {
+
+	app := newTestApp(t)
+	user := saveUser(t, app, "caps@test.local")
+
+	c, err := app.FindCollectionByNameOrId("activity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	save := func(state, rawlog string) *core.Record {
+		activity := core.NewRecord(c)
+		activity.Load(map[string]any{"user": user.Id, "isCall": true, "state": state, "rawlog": rawlog})
+		if err := app.Save(activity); err != nil {
+			t.Fatal(err)
+		}
+		return activity
+	}
+
+	call := `"call":{"OriCaller":"100","Gateway":"gw1"}`
+	startEpoch := func(ago time.Duration) string {
+		return fmt.Sprintf(`"fslega":{},"state":{"start_epoch":%d}`, time.Now().Add(-ago).Unix())
+	}
+
+	dialing := save(callstate.Dialing, `{`+call+`}`)
+	save(callstate.Answered, `{`+call+`}`)
+	// ended by ESL, the cdr not arrived yet
+	save(callstate.Ended, `{`+call+`}`)
+	// failed before dialing, and never reported by FreeSWITCH
+	save(callstate.Failed, `{`+call+`,"fail":{"code":"caps_reached"}}`)
+	save(callstate.Abandoned, `{`+call+`,"abandon":{"reason":"not_dialed"}}`)
+	// finished a minute and two hours ago
+	save(callstate.Ended, `{`+call+`,`+startEpoch(time.Minute)+`}`)
+	save(callstate.Failed, `{`+call+`,`+startEpoch(2*time.Hour)+`}`)
+	// another number
+	save(callstate.Dialing, `{"call":{"OriCaller":"200","Gateway":"gw1"}}`)
+
+	for _, tc := range []struct {
+		key, value, activity string
+		window               time.Duration
+		active               bool
+		want                 int64
+	}{
+		{"OriCaller", "100", "", activeCallTimeout, true, 2},
+		{"OriCaller", "100", dialing.Id, activeCallTimeout, true, 1},
+		{"OriCaller", "100", "", time.Hour, false, 4},
+		{"OriCaller", "100", "", 24 * time.Hour, false, 5},
+		{"OriCaller", "200", "", activeCallTimeout, true, 1},
+		{"Gateway", "gw1", "", activeCallTimeout, true, 3},
+	} {
+		n, err := countCalls(app, tc.key, tc.value, tc.activity, tc.window, tc.active)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.want, n, "%s %s window %v active %v", tc.key, tc.value, tc.window, tc.active)
+	}
+
+	// a call failed when dialing holds no concurrent slot of its number
+	capped := core.NewRecord(core.NewBaseCollection("number"))
+	capped.Set("maxConcurrent", 3)
+	reason, err := capsOf(capped).reached(app, "OriCaller", "100", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "", reason)
+	capped.Set("maxConcurrent", 2)
+	reason, err = capsOf(capped).reached(app, "OriCaller", "100", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "maxConcurrent reached(2/2)", reason)
+}
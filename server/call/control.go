@@ -0,0 +1,133 @@
+package call
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/tcmzzz/lightcall/server/esl"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// actions to control a call in progress over ESL
+const (
+	ControlHangup = "hangup"
+	ControlHold   = "hold"
+	ControlUnhold = "unhold"
+	ControlDtmf   = "dtmf"
+)
+
+var dtmfRe = regexp.MustCompile(`^[0-9A-Da-d*#wW]{1,32}$`)
+
+type dtmfForm struct {
+	Digits string `json:"digits"`
+}
+
+// HandleCallControl runs the action on the call of the activity, for its user or an admin.
+// The channel is of rawlog.live, so ESL must be connected, and its activityId variable
+// must be the activity.
+func HandleCallControl(client *esl.Client, action string) func(*core.RequestEvent) error {
+
+	return func(se *core.RequestEvent) error {
+		app, user := se.App, se.Auth
+		activityID := se.Request.PathValue("activityId")
+
+		activity, err := app.FindRecordById("activity", activityID)
+		if err != nil {
+			return apiError(failed(ErrActivityNotFound, err))
+		}
+		if activity.GetString("user") != user.Id && !user.GetBool("isAdmin") {
+			return apiError(failf(ErrForbidden, "activity %s is not of user %s", activity.Id, user.Id))
+		}
+
+		digits := ""
+		if action == ControlDtmf {
+			form := &dtmfForm{}
+			if err := se.BindBody(form); err != nil {
+				return apiError(failed(ErrInvalidRequest, err))
+			}
+			digits = form.Digits
+		}
+
+		cmd, err := controlCmd(action, esl.LiveOf(activity), digits)
+		if err != nil {
+			return apiError(asCallError(err))
+		}
+
+		if client == nil || !client.Connected() {
+			return apiError(failf(ErrEslUnavailable, "esl not connected"))
+		}
+		if err := checkChannel(client, controlChannel(action, esl.LiveOf(activity)), activity.Id); err != nil {
+			return apiError(asCallError(err))
+		}
+		if _, err := client.Api(cmd); err != nil {
+			return apiError(eslError(err))
+		}
+
+		app.Logger().Info("call control", "activity", activity.Id, "user", user.Id, "action", action, "cmd", cmd)
+		return se.JSON(200, map[string]any{"action": action, "activity": activity.Id})
+	}
+}
+
+// controlCmd is the api command of the action on the call.
+// DTMF goes to the b-leg, towards the IVR called, the others act on the agent's a-leg.
+func controlCmd(action string, live esl.Live, digits string) (string, error) {
+	if live.State == esl.LiveHangup {
+		return "", failf(ErrNoChannel, "call hung up")
+	}
+
+	switch action {
+	case ControlHangup, ControlHold, ControlUnhold:
+		if live.UUID == "" {
+			return "", failf(ErrNoChannel, "no channel of the call")
+		}
+	case ControlDtmf:
+		if live.BLeg == "" {
+			return "", failf(ErrNoChannel, "no channel of the callee")
+		}
+		if !dtmfRe.MatchString(digits) {
+			return "", failf(ErrInvalidRequest, "invalid dtmf digits %q", digits)
+		}
+	}
+
+	switch action {
+	case ControlHangup:
+		return "uuid_kill " + live.UUID + " NORMAL_CLEARING", nil
+	case ControlHold:
+		return "uuid_hold " + live.UUID, nil
+	case ControlUnhold:
+		return "uuid_hold off " + live.UUID, nil
+	case ControlDtmf:
+		return "uuid_send_dtmf " + live.BLeg + " " + digits, nil
+	}
+	return "", failf(ErrInvalidRequest, "unknown action %q", action)
+}
+
+// controlChannel is the channel the action acts on, see controlCmd.
+func controlChannel(action string, live esl.Live) string {
+	if action == ControlDtmf {
+		return live.BLeg
+	}
+	return live.UUID
+}
+
+// checkChannel checks the channel is of the activity by its activityId variable,
+// which the dialplan exports to both legs.
+func checkChannel(client *esl.Client, channel, activityID string) error {
+	v, err := client.Api("uuid_getvar " + channel + " activityId")
+	if err != nil {
+		return eslError(err)
+	}
+	if v != activityID {
+		return failf(ErrNoChannel, "channel %s is of activity %q, not %s", channel, v, activityID)
+	}
+	return nil
+}
+
+// eslError is the failure of an api command, ErrNoChannel when the channel is gone.
+func eslError(err error) *CallError {
+	if strings.Contains(err.Error(), "No such channel") {
+		return failed(ErrNoChannel, err)
+	}
+	return failed(ErrInternal, err)
+}
@@ -0,0 +1,47 @@
+package call
+
+import (
+	"testing"
+
+	"github.com/tcmzzz/lightcall/server/esl"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlCmd(t *testing.T) {
+
+	live := esl.Live{State: esl.LiveBridged, UUID: "a", BLeg: "b"}
+
+	for action, want := range map[string]string{
+		ControlHangup: "uuid_kill a NORMAL_CLEARING",
+		ControlHold:   "uuid_hold a",
+		ControlUnhold: "uuid_hold off a",
+	} {
+		cmd, err := controlCmd(action, live, "")
+		assert.Nil(t, err)
+		assert.Equal(t, want, cmd)
+	}
+
+	assert.Equal(t, "a", controlChannel(ControlHangup, live))
+	assert.Equal(t, "b", controlChannel(ControlDtmf, live))
+
+	cmd, err := controlCmd(ControlDtmf, live, "1w2#")
+	assert.Nil(t, err)
+	assert.Equal(t, "uuid_send_dtmf b 1w2#", cmd)
+
+	_, err = controlCmd(ControlDtmf, live, "1; uuid_kill x")
+	assert.True(t, errors.Is(err, ErrInvalidRequest))
+	_, err = controlCmd(ControlDtmf, live, "")
+	assert.True(t, errors.Is(err, ErrInvalidRequest))
+	_, err = controlCmd("transfer", live, "")
+	assert.True(t, errors.Is(err, ErrInvalidRequest))
+
+	// no channel yet, or hung up
+	_, err = controlCmd(ControlHangup, esl.Live{}, "")
+	assert.True(t, errors.Is(err, ErrNoChannel))
+	_, err = controlCmd(ControlDtmf, esl.Live{State: esl.LiveRinging, UUID: "a"}, "1")
+	assert.True(t, errors.Is(err, ErrNoChannel))
+	_, err = controlCmd(ControlHold, esl.Live{State: esl.LiveHangup, UUID: "a", BLeg: "b"}, "")
+	assert.True(t, errors.Is(err, ErrNoChannel))
+}
@@ -0,0 +1,127 @@
+package call
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// dialplanFuncs are the functions of the dialplan templates, the numbers come from users
+// and are escaped by xml.
+var dialplanFuncs = template.FuncMap{"xml": xmlEscape}
+
+// parseDialplan parses the dialplan template of an outgw, fsTplBridge when tpl is empty.
+func parseDialplan(tpl string) (*template.Template, error) {
+	if tpl == "" {
+		tpl = fsTplBridge
+	}
+	t, err := template.New("bridge").Funcs(dialplanFuncs).Parse(tpl)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse dialplan")
+	}
+	return t, nil
+}
+
+// CheckDialplan checks the dialplan template saved in outgw: it parses, renders
+// with a sample call of two attempts, and the result is well-formed XML.
+// The sample is rendered again with numbers holding XML markup, which must be escaped by xml.
+func CheckDialplan(tpl string, options map[string]any) error {
+	if options == nil {
+		options = map[string]any{}
+	}
+
+	out, err := sampleDialplan(options, "").Fmt(tpl)
+	if err != nil {
+		return err
+	}
+	if err := wellFormed(out); err != nil {
+		return err
+	}
+
+	out, err = sampleDialplan(options, `"/><x a='&`).Fmt(tpl)
+	if err != nil {
+		return err
+	}
+	if err := wellFormed(out); err != nil {
+		return errors.Wrap(err, "numbers not escaped, write them as {{xml .DialStr}}")
+	}
+	return nil
+}
+
+// sampleDialplan is a call of two attempts, markup is appended to the numbers of the call.
+func sampleDialplan(options map[string]any, markup string) fsTplBridgeParam {
+	sample := fsTplBridgeParam{
+		UserID:         "user0000000001",
+		TaskID:         "task0000000001",
+		ActivityID:     "activity000001",
+		OriCallee:      "13800138000" + markup,
+		ContinueOnFail: "NORMAL_TEMPORARY_FAILURE",
+		RecordFile:     "2025-01-01/activity000001.mp3",
+		RecordStereo:   true,
+		Gateway:        "outgw000000001",
+		Options:        options,
+	}
+	for i, caller := range []string{"01012345678", "02087654321"} {
+		sample.Attempts = append(sample.Attempts, fsTplAttempt{
+			Attempt:   i + 1,
+			OriCaller: caller + markup,
+			Caller:    caller + markup,
+			Callee:    "013800138000" + markup,
+			DialStr:   "013800138000" + markup + "@gw.example.com:5060",
+			Gateway:   sample.Gateway,
+			Options:   options,
+		})
+	}
+	return sample
+}
+
+// wellFormed checks s is an XML document with a single root element.
+func wellFormed(s string) error {
+	dec := xml.NewDecoder(bytes.NewBufferString(s))
+	depth, roots := 0, 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "invalid xml")
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				roots++
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if depth == 0 && len(bytes.TrimSpace(t)) > 0 {
+				return errors.New("invalid xml: text outside the root element")
+			}
+		}
+	}
+	if roots != 1 {
+		return errors.Errorf("invalid xml: %d root elements", roots)
+	}
+	return nil
+}
+
+// gatewayOptions is the options json of the outgw for the dialplan, empty when gw is nil.
+func gatewayOptions(app core.App, gw *core.Record) map[string]any {
+	opts := map[string]any{}
+	if gw == nil {
+		return opts
+	}
+	if err := gw.UnmarshalJSONField("options", &opts); err != nil && gw.GetString("options") != "" {
+		app.Logger().Warn("failed to parse outgw options", "outgw", gw.Id, "err", err)
+	}
+	if opts == nil {
+		opts = map[string]any{}
+	}
+	return opts
+}
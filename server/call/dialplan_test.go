@@ -0,0 +1,85 @@
+package call
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckDialplan(t *testing.T) {
+
+	// the default one, and the fail one
+	assert.Nil(t, CheckDialplan("", nil))
+	assert.Nil(t, wellFormed(fsFmtFailTpl(480, "No Caller Available", nil)))
+
+	custom := `<document type="freeswitch/xml">
+  <section name="dialplan">
+   <context name="public">
+    <extension name="bridge">
+      <condition>
+        <action application="set" data="call_timeout={{or .Options.callTimeout 30}}"/>
+        {{- range .Attempts}}
+        <action application="bridge" data="{sip_h_X-Task={{xml $.TaskID}}}sofia/{{or .Options.profile "internal"}}/{{xml .DialStr}}"/>
+        {{- end}}
+      </condition>
+    </extension>
+   </context>
+  </section>
+</document>`
+	assert.Nil(t, CheckDialplan(custom, map[string]any{"profile": "external"}))
+
+	invalid := []struct {
+		tpl string
+		err string
+	}{
+		{`<document>{{.TaskID</document>`, "parse dialplan"},
+		{`<document>{{.Unknown}}</document>`, "render dialplan"},
+		{`<document>{{index .Attempts 5}}</document>`, "render dialplan"},
+		{`<document><section></document>`, "invalid xml"},
+		{`<document description=""">{{.TaskID}}</document>`, "invalid xml"},
+		{`<a/><b/>`, "2 root elements"},
+		{`just text`, "invalid xml"},
+		{`<document>{{range .Attempts}}<a data="{{.DialStr}}"/>{{end}}</document>`, "numbers not escaped"},
+		{`<document>{{.OriCallee}}</document>`, "numbers not escaped"},
+	}
+	for _, c := range invalid {
+		err := CheckDialplan(c.tpl, nil)
+		if assert.Error(t, err, c.tpl) {
+			assert.Contains(t, err.Error(), c.err, c.tpl)
+		}
+	}
+}
+
+func TestFmtDialplan(t *testing.T) {
+
+	p := fsTplBridgeParam{
+		TaskID:  "t1",
+		Gateway: "gw1",
+		Options: map[string]any{"profile": "external"},
+		Attempts: []fsTplAttempt{
+			{Attempt: 1, DialStr: "1@a", Options: map[string]any{"profile": "external"}},
+			{Attempt: 2, DialStr: "2@b", Options: map[string]any{}},
+		},
+	}
+
+	out, err := p.Fmt(`{{range .Attempts}}sofia/{{or .Options.profile "internal"}}/{{.DialStr}};{{end}}`)
+	assert.Nil(t, err)
+	assert.Equal(t, "sofia/external/1@a;sofia/internal/2@b;", out)
+
+	// the default one bridges every attempt
+	out, err = p.Fmt("")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, strings.Count(out, `application="bridge"`))
+	assert.Contains(t, out, `data="{lc_attempt=2}sofia/internal/2@b"`)
+
+	// the numbers can not break out of the attributes of the default one
+	p.OriCallee = `1"/><action application="system" data="reboot`
+	p.Attempts[0].Callee = `1'&<`
+	p.Attempts[0].DialStr = `1"/><x a="@a`
+	out, err = p.Fmt("")
+	assert.Nil(t, err)
+	assert.Nil(t, wellFormed(out))
+	assert.NotContains(t, out, `application="system"`)
+	assert.Contains(t, out, `data="oriCallee=1&#34;/&gt;&lt;action application=&#34;system&#34; data=&#34;reboot"`)
+}
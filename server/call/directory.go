@@ -0,0 +1,167 @@
+package call
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// sipCredentialTTL is how long a SIP password is issued for. Passwords are of fixed windows of the TTL,
+// a client refreshes its password by the expires of the credential, the password of the previous
+// window is still accepted meanwhile.
+const sipCredentialTTL = 10 * time.Minute
+
+// fsDirForm is the xml_curl request of the directory section.
+// The sip_auth_* params come with the lookups of a digest challenged REGISTER or INVITE.
+type fsDirForm struct {
+	Section string `json:"section" form:"section"`
+	User    string `json:"user" form:"user"`
+	Domain  string `json:"domain" form:"domain"`
+	Action  string `json:"action" form:"action"`
+
+	AuthUsername string `json:"sip_auth_username" form:"sip_auth_username"`
+	AuthRealm    string `json:"sip_auth_realm" form:"sip_auth_realm"`
+	AuthNonce    string `json:"sip_auth_nonce" form:"sip_auth_nonce"`
+	AuthURI      string `json:"sip_auth_uri" form:"sip_auth_uri"`
+	AuthQop      string `json:"sip_auth_qop" form:"sip_auth_qop"`
+	AuthCnonce   string `json:"sip_auth_cnonce" form:"sip_auth_cnonce"`
+	AuthNc       string `json:"sip_auth_nc" form:"sip_auth_nc"`
+	AuthMethod   string `json:"sip_auth_method" form:"sip_auth_method"`
+	AuthResponse string `json:"sip_auth_response" form:"sip_auth_response"`
+}
+
+// digestMatches tells whether the digest response of the request is of the password, RFC 2617.
+func (f *fsDirForm) digestMatches(password string) bool {
+	if f.AuthResponse == "" {
+		return false
+	}
+	ha1 := md5Hex(f.AuthUsername + ":" + f.AuthRealm + ":" + password)
+	ha2 := md5Hex(f.AuthMethod + ":" + f.AuthURI)
+	response := md5Hex(ha1 + ":" + f.AuthNonce + ":" + ha2)
+	if f.AuthQop != "" {
+		response = md5Hex(strings.Join([]string{ha1, f.AuthNonce, f.AuthNc, f.AuthCnonce, f.AuthQop, ha2}, ":"))
+	}
+	return hmac.Equal([]byte(response), []byte(strings.ToLower(f.AuthResponse)))
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// sipCredential is the SIP account of a user to register to FreeSWITCH.
+type sipCredential struct {
+	Username string    `json:"username"`
+	Password string    `json:"password"`
+	Expires  time.Time `json:"expires"`
+}
+
+// HandleFsDirectory answers the xml_curl directory requests of FreeSWITCH.
+// A user is found only when it is active, with the password of the current window,
+// so deactivating a user or invalidating its auth tokens blocks the SIP registration at once.
+func HandleFsDirectory(se *core.RequestEvent) error {
+	app, form := se.App, &fsDirForm{}
+
+	if err := se.BindBody(form); err != nil {
+		app.Logger().Error("bind req fail", "err", err)
+		return se.String(200, fsTplNotFound)
+	}
+
+	if form.Section != "directory" || form.User == "" {
+		return se.String(200, fsTplNotFound)
+	}
+
+	user, err := app.FindRecordById("users", form.User)
+	if err != nil {
+		app.Logger().Warn("sip user not found", "user", form.User, "action", form.Action)
+		return se.String(200, fsTplNotFound)
+	}
+	if !user.GetBool("active") {
+		app.Logger().Warn("sip user not active", "user", user.Id, "action", form.Action)
+		return se.String(200, fsTplNotFound)
+	}
+
+	return se.String(200, fsFmtDirectory(form.Domain, user.Id, user.GetString("name"), directoryPassword(form, user, time.Now()), app.Logger()))
+}
+
+// HandleSipCredential gives the SIP credential of the current window to the auth user.
+func HandleSipCredential(se *core.RequestEvent) error {
+	if !se.Auth.GetBool("active") {
+		return apiError(failf(ErrUnauthorized, "user %s not active", se.Auth.Id))
+	}
+	return se.JSON(200, sipCredentialOf(se.Auth, time.Now()))
+}
+
+// directoryPassword is the password of the current window, or the one of the previous window
+// when the digest of the request is of it, the client has not refreshed its credential yet.
+func directoryPassword(form *fsDirForm, user *core.Record, now time.Time) string {
+	password := sipCredentialOf(user, now).Password
+	if form.AuthResponse == "" || form.digestMatches(password) {
+		return password
+	}
+	if prev := sipCredentialOf(user, now.Add(-sipCredentialTTL)).Password; form.digestMatches(prev) {
+		return prev
+	}
+	return password
+}
+
+func sipCredentialOf(user *core.Record, now time.Time) sipCredential {
+	window := now.Unix() / int64(sipCredentialTTL/time.Second)
+	key := user.TokenKey() + user.Collection().AuthToken.Secret
+	return sipCredential{
+		Username: user.Id,
+		Password: sipPassword(key, user.Id, window),
+		Expires:  time.Unix((window+1)*int64(sipCredentialTTL/time.Second), 0).UTC(),
+	}
+}
+
+// sipPassword is the password of the user in the window, signed with the key of its auth tokens.
+func sipPassword(key, userID string, window int64) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(userID + ":" + strconv.FormatInt(window, 10)))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+func fsFmtDirectory(domain, userID, name, password string, logger *slog.Logger) string {
+
+	buf := bytes.NewBufferString("")
+	t := template.Must(template.New("directory").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(fsTplDirectory))
+	err := t.Execute(buf, map[string]any{
+		"Domain":   domain,
+		"UserID":   userID,
+		"Name":     name,
+		"Password": password,
+	})
+	if err != nil {
+		logger.Warn("format tpl fail", "error", err)
+	}
+	return buf.String()
+}
+
+const fsTplDirectory = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<document type="freeswitch/xml">
+  <section name="directory">
+    <domain name="{{xml .Domain}}">
+      <user id="{{xml .UserID}}">
+        <params>
+          <param name="password" value="{{xml .Password}}"/>
+          <param name="dial-string" value="{^^:sip_invite_domain=${dialed_domain}:presence_id=${dialed_user}@${dialed_domain}}${sofia_contact(*/${dialed_user}@${dialed_domain})}"/>
+        </params>
+        <variables>
+          <variable name="user_context" value="public"/>
+          <variable name="effective_caller_id_name" value="{{xml .Name}}"/>
+          <variable name="effective_caller_id_number" value="{{xml .UserID}}"/>
+        </variables>
+      </user>
+    </domain>
+  </section>
+</document>`
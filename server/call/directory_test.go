@@ -0,0 +1,88 @@
+package call
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSipPassword(t *testing.T) {
+
+	p := sipPassword("key", "user1", 100)
+	assert.Len(t, p, 32)
+	assert.Equal(t, p, sipPassword("key", "user1", 100))
+
+	// another window, another token key or another user is another password
+	assert.NotEqual(t, p, sipPassword("key", "user1", 101))
+	assert.NotEqual(t, p, sipPassword("key2", "user1", 100))
+	assert.NotEqual(t, p, sipPassword("key", "user2", 100))
+}
+
+func TestFsFmtDirectory(t *testing.T) {
+
+	s := fsFmtDirectory("10.0.0.1", "user1", `小李<"a">`, "pw", slog.Default())
+	assert.Nil(t, wellFormed(s))
+	assert.True(t, strings.Contains(s, `<user id="user1">`))
+	assert.True(t, strings.Contains(s, `<param name="password" value="pw"/>`))
+	assert.True(t, strings.Contains(s, `value="小李&lt;&#34;a&#34;&gt;"`))
+}
+
+func TestDigestMatches(t *testing.T) {
+
+	// the example of RFC 2617
+	form := &fsDirForm{
+		AuthUsername: "Mufasa",
+		AuthRealm:    "testrealm@host.com",
+		AuthNonce:    "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+		AuthURI:      "/dir/index.html",
+		AuthQop:      "auth",
+		AuthCnonce:   "0a4f113b",
+		AuthNc:       "00000001",
+		AuthMethod:   "GET",
+		AuthResponse: "6629fae49393a05397450978507c4ef1",
+	}
+	assert.True(t, form.digestMatches("Circle Of Life"))
+	assert.False(t, form.digestMatches("circle of life"))
+
+	form.AuthResponse = ""
+	assert.False(t, form.digestMatches("Circle Of Life"))
+}
+
+func TestDirectoryPassword(t *testing.T) {
+
+	user := core.NewRecord(core.NewAuthCollection("users"))
+	user.Id = "user1"
+	user.SetTokenKey("key")
+
+	now := time.Unix(1700000000, 0)
+	current := sipCredentialOf(user, now).Password
+	prev := sipCredentialOf(user, now.Add(-sipCredentialTTL)).Password
+	older := sipCredentialOf(user, now.Add(-2*sipCredentialTTL)).Password
+	assert.NotEqual(t, current, prev)
+
+	digest := func(password string) *fsDirForm {
+		hash := func(s string) string {
+			sum := md5.Sum([]byte(s))
+			return hex.EncodeToString(sum[:])
+		}
+		form := &fsDirForm{AuthUsername: "user1", AuthRealm: "10.0.0.1", AuthNonce: "n", AuthURI: "sip:10.0.0.1", AuthMethod: "REGISTER"}
+		form.AuthResponse = hash(hash("user1:10.0.0.1:"+password) + ":n:" + hash("REGISTER:sip:10.0.0.1"))
+		return form
+	}
+
+	// lookups without digest, e.g. to dial the user, have the current one
+	assert.Equal(t, current, directoryPassword(&fsDirForm{}, user, now))
+	assert.Equal(t, current, directoryPassword(digest(current), user, now))
+
+	// the client has not refreshed yet
+	assert.Equal(t, prev, directoryPassword(digest(prev), user, now))
+
+	// too old, fails the challenge
+	assert.Equal(t, current, directoryPassword(digest(older), user, now))
+}
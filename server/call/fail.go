@@ -0,0 +1,136 @@
+package call
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/tcmzzz/lightcall/server/callstate"
+
+	"github.com/pkg/errors"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/router"
+)
+
+// Failure is a kind of reason a call is not placed. FreeSWITCH answers the agent
+// with its SIP response, the JSON APIs return its code and http status.
+type Failure struct {
+	Code    string
+	Message string
+	Status  int // http status of the JSON APIs
+	SipCode int
+	SipMsg  string
+	Label   string // 呼叫失败原因, 写入活动备注
+}
+
+func (f *Failure) Error() string { return f.Message }
+
+var (
+	ErrInvalidRequest      = &Failure{"invalid_request", "invalid request", http.StatusBadRequest, 400, "Invalid Request", "请求无效"}
+	ErrUnauthorized        = &Failure{"unauthorized", "unauthorized", http.StatusUnauthorized, 401, "Unauthorized", "未登录或登录已失效"}
+	ErrTaskNotFound        = &Failure{"task_not_found", "task not found", http.StatusNotFound, 404, "Task Not Found", "任务不存在"}
+	ErrActivityNotFound    = &Failure{"activity_not_found", "activity not found", http.StatusNotFound, 404, "Activity Not Found", "活动不存在"}
+	ErrNotOwner            = &Failure{"not_owner", "not allowed to call", http.StatusForbidden, 403, "Not Task Owner", "不是任务负责人"}
+	ErrOutsideCallingHours = &Failure{"outside_calling_hours", "outside calling hours", http.StatusForbidden, 403, "Outside Calling Hours", "不在允许呼叫的时段"}
+	ErrPreCallBlocked      = &Failure{"precall_blocked", "blocked by precall", http.StatusForbidden, 603, "Blocked By PreCall", "被呼叫前检查拦截"}
+	ErrNoCaller            = &Failure{"no_caller", "no caller available", http.StatusServiceUnavailable, 480, "No Caller Available", "没有可用的主叫号码"}
+	ErrGatewayDisabled     = &Failure{"gateway_disabled", "gateway disabled", http.StatusServiceUnavailable, 503, "Gateway Disabled", "线路已停用"}
+	ErrCapsReached         = &Failure{"caps_reached", "call caps reached", http.StatusTooManyRequests, 486, "Call Caps Reached", "主叫号码已达呼叫上限"}
+	ErrTransFailed         = &Failure{"trans_failed", "number transform failed", http.StatusInternalServerError, 484, "Number Transform Failed", "号码变换失败"}
+	ErrDialplanInvalid     = &Failure{"dialplan_invalid", "invalid dialplan", http.StatusInternalServerError, 500, "Dialplan Invalid", "线路拨号模板错误"}
+	ErrForbidden           = &Failure{"forbidden", "not allowed", http.StatusForbidden, 403, "Forbidden", "无权操作该活动"}
+	ErrActivityDialed      = &Failure{"activity_dialed", "activity already dialed", http.StatusConflict, 403, "Activity Already Dialed", "活动已拨打过"}
+	ErrNoChannel           = &Failure{"no_channel", "call not in progress", http.StatusConflict, 481, "Call Does Not Exist", "通话不存在或已结束"}
+	ErrEslUnavailable      = &Failure{"esl_unavailable", "esl unavailable", http.StatusServiceUnavailable, 503, "ESL Unavailable", "未连接 FreeSWITCH"}
+	ErrInternal            = &Failure{"internal", "internal error", http.StatusInternalServerError, 500, "Internal Error", "系统错误"}
+)
+
+// CallError is why a call is not placed: the Failure and its detail.
+// errors.Is(err, ErrNoCaller) tells whether err is a CallError of ErrNoCaller.
+type CallError struct {
+	Failure *Failure
+	Reason  string
+	cause   error
+}
+
+func (e *CallError) Error() string {
+	if e.Reason == "" {
+		return e.Failure.Message
+	}
+	return e.Failure.Message + ": " + e.Reason
+}
+
+func (e *CallError) Is(target error) bool { return target == e.Failure }
+
+func (e *CallError) Cause() error { return e.cause }
+
+func (e *CallError) Unwrap() error { return e.cause }
+
+// Code and Params make the error a public safe item of the api error data.
+func (e *CallError) Code() string { return e.Failure.Code }
+
+func (e *CallError) Params() map[string]any {
+	return map[string]any{"sipCode": e.Failure.SipCode, "sipMsg": e.Failure.SipMsg}
+}
+
+// MarshalJSON is how the failure is saved in rawlog.fail.
+func (e *CallError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"code":    e.Failure.Code,
+		"message": e.Error(),
+		"sipCode": e.Failure.SipCode,
+		"sipMsg":  e.Failure.SipMsg,
+	})
+}
+
+func failf(f *Failure, format string, args ...any) *CallError {
+	return &CallError{Failure: f, Reason: fmt.Sprintf(format, args...)}
+}
+
+// failed makes err a CallError of f, err is kept as is when it is already a CallError.
+func failed(f *Failure, err error) *CallError {
+	var ce *CallError
+	if errors.As(err, &ce) {
+		return ce
+	}
+	return &CallError{Failure: f, Reason: err.Error(), cause: err}
+}
+
+// asCallError is the CallError of err, ErrInternal when err is not one.
+func asCallError(err error) *CallError {
+	return failed(ErrInternal, err)
+}
+
+// apiError is the api error of a CallError, its code is in data.call.code.
+func apiError(ce *CallError) *router.ApiError {
+	return router.NewApiError(ce.Failure.Status, ce.Error(), map[string]any{"call": ce})
+}
+
+// saveFail records the failure in rawlog.fail of the activity and tells it in the comment.
+// The activity is related to the task so the agent sees it, as the cdr does for calls placed,
+// taskID may be empty when the task is unknown.
+func saveFail(app core.App, activity *core.Record, taskID string, ce *CallError) error {
+	if err := setRawlog(activity, map[string]any{"fail": ce}); err != nil {
+		return err
+	}
+	activity.Set("comment", fmt.Sprintf("呼叫失败(%s)", ce.Failure.Label))
+	callstate.Transit(activity, callstate.Failed)
+	if taskID != "" && activity.GetString("task") == "" {
+		activity.Set("task", taskID)
+	}
+
+	return app.RunInTransaction(func(txApp core.App) error {
+		if err := txApp.Save(activity); err != nil {
+			return err
+		}
+		if taskID == "" {
+			return nil
+		}
+		task, err := txApp.FindRecordById("task", taskID)
+		if err != nil {
+			return err
+		}
+		task.Set("activity+", activity.Id)
+		return txApp.Save(task)
+	})
+}
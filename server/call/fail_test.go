@@ -0,0 +1,54 @@
+package call
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallError(t *testing.T) {
+
+	err := error(failf(ErrNoCaller, "no enabled number in the pool of %s", "objective"))
+	assert.True(t, errors.Is(err, ErrNoCaller))
+	assert.False(t, errors.Is(err, ErrCapsReached))
+	assert.Equal(t, "no caller available: no enabled number in the pool of objective", err.Error())
+
+	// wrapping keeps the failure
+	wrapped := errors.Wrap(err, "plan")
+	assert.True(t, errors.Is(wrapped, ErrNoCaller))
+	assert.Equal(t, ErrNoCaller, asCallError(wrapped).Failure)
+
+	// other errors are internal, keeping the cause
+	cause := errors.New("db locked")
+	ce := asCallError(cause)
+	assert.Equal(t, ErrInternal, ce.Failure)
+	assert.Equal(t, "internal error: db locked", ce.Error())
+	assert.True(t, errors.Is(ce, cause))
+
+	b, err := json.Marshal(map[string]any{"fail": failf(ErrTransFailed, "transcallee of gw1: bad")})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"fail":{"code":"trans_failed","message":"number transform failed: transcallee of gw1: bad","sipCode":484,"sipMsg":"Number Transform Failed"}}`, string(b))
+
+	b, err = json.Marshal(apiError(failf(ErrCapsReached, "all 2 enabled numbers")))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"status":429,"message":"Call caps reached: all 2 enabled numbers.","data":{"call":{"code":"caps_reached","message":"Call caps reached: all 2 enabled numbers.","params":{"sipCode":486,"sipMsg":"Call Caps Reached"}}}}`, string(b))
+}
+
+func TestNoCaller(t *testing.T) {
+
+	cs := []struct {
+		rejected []Rejection
+		expected *Failure
+	}{
+		{[]Rejection{}, ErrNoCaller},
+		{[]Rejection{{Reason: "number disabled"}}, ErrNoCaller},
+		{[]Rejection{{Reason: "number disabled"}, {Reason: "gateway disabled"}}, ErrGatewayDisabled},
+		{[]Rejection{{Reason: "no gateway"}}, ErrGatewayDisabled},
+	}
+
+	for _, c := range cs {
+		assert.True(t, errors.Is(noCaller(c.rejected), c.expected), c.rejected)
+	}
+}
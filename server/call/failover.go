@@ -0,0 +1,89 @@
+package call
+
+import (
+	"strings"
+
+	"github.com/tcmzzz/lightcall/server/config"
+)
+
+// sipCauses maps SIP response codes to the hangup causes FreeSWITCH reports
+// for them, as continue_on_fail only matches hangup causes.
+var sipCauses = map[string]string{
+	"400": "NORMAL_TEMPORARY_FAILURE",
+	"401": "CALL_REJECTED",
+	"402": "CALL_REJECTED",
+	"403": "CALL_REJECTED",
+	"404": "UNALLOCATED_NUMBER",
+	"405": "SERVICE_UNAVAILABLE",
+	"406": "SERVICE_NOT_IMPLEMENTED",
+	"407": "CALL_REJECTED",
+	"408": "RECOVERY_ON_TIMER_EXPIRE",
+	"410": "NUMBER_CHANGED",
+	"413": "INTERWORKING",
+	"414": "INTERWORKING",
+	"415": "SERVICE_NOT_IMPLEMENTED",
+	"416": "INTERWORKING",
+	"420": "INTERWORKING",
+	"421": "INTERWORKING",
+	"423": "INTERWORKING",
+	"480": "NO_USER_RESPONSE",
+	"481": "NORMAL_TEMPORARY_FAILURE",
+	"482": "EXCHANGE_ROUTING_ERROR",
+	"483": "EXCHANGE_ROUTING_ERROR",
+	"484": "INVALID_NUMBER_FORMAT",
+	"485": "NO_ROUTE_DESTINATION",
+	"486": "USER_BUSY",
+	"487": "ORIGINATOR_CANCEL",
+	"488": "INCOMPATIBLE_DESTINATION",
+	"500": "NORMAL_TEMPORARY_FAILURE",
+	"501": "SERVICE_NOT_IMPLEMENTED",
+	"502": "NETWORK_OUT_OF_ORDER",
+	"503": "NORMAL_TEMPORARY_FAILURE",
+	"504": "RECOVERY_ON_TIMER_EXPIRE",
+	"505": "INTERWORKING",
+	"513": "INTERWORKING",
+	"600": "USER_BUSY",
+	"603": "CALL_REJECTED",
+	"604": "NO_ROUTE_DESTINATION",
+	"606": "INCOMPATIBLE_DESTINATION",
+}
+
+// maxAttempts is how many callers are dialed at most, the first one included.
+func maxAttempts(f config.Failover) int {
+	if f.Attempts < 1 {
+		return 1
+	}
+	return f.Attempts
+}
+
+// continueOnFail formats the causes as the value of the continue_on_fail channel variable.
+// SIP response codes are translated to hangup causes, anything else is kept as is.
+// It returns "" when no cause is configured, so a failed bridge ends the call.
+func continueOnFail(causes []string) string {
+	seen := make(map[string]bool)
+	list := make([]string, 0, len(causes))
+	for _, c := range causes {
+		c = strings.TrimSpace(c)
+		if cause, ok := sipCauses[c]; ok {
+			c = cause
+		}
+		if c == "" || seen[c] {
+			continue
+		}
+		seen[c] = true
+		list = append(list, c)
+	}
+	return strings.Join(list, ",")
+}
+
+// otherGateways returns the candidates not on the gateway, so a failover
+// attempt does not hit the gateway that just failed.
+func otherGateways(cs []*Candidate, gateway string) []*Candidate {
+	others := make([]*Candidate, 0, len(cs))
+	for _, c := range cs {
+		if c.Record.GetString("outgw") != gateway {
+			others = append(others, c)
+		}
+	}
+	return others
+}
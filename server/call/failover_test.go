@@ -0,0 +1,78 @@
+package call
+
+import (
+	"testing"
+
+	"github.com/tcmzzz/lightcall/server/config"
+
+	"github.com/pkg/errors"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContinueOnFail(t *testing.T) {
+	assert.Equal(t, "", continueOnFail(nil))
+	assert.Equal(t, "NORMAL_TEMPORARY_FAILURE,CALL_REJECTED", continueOnFail([]string{"503", " 403", "NORMAL_TEMPORARY_FAILURE", ""}))
+	assert.Equal(t, "USER_BUSY,GATEWAY_DOWN", continueOnFail([]string{"486", "GATEWAY_DOWN"}))
+}
+
+func TestMaxAttempts(t *testing.T) {
+	assert.Equal(t, 1, maxAttempts(config.Failover{}))
+	assert.Equal(t, 1, maxAttempts(config.Failover{Attempts: -1}))
+	assert.Equal(t, 3, maxAttempts(config.Failover{Attempts: 3}))
+}
+
+func TestOtherGateways(t *testing.T) {
+	candidate := func(id, gw string) *Candidate {
+		r := core.NewRecord(core.NewBaseCollection("number"))
+		r.Id = id
+		r.Set("outgw", gw)
+		return &Candidate{Record: r, Weight: 1}
+	}
+
+	cs := []*Candidate{candidate("a", "gw1"), candidate("b", "gw2"), candidate("c", "gw1"), candidate("d", "gw3")}
+
+	others := otherGateways(cs, "gw1")
+	assert.Len(t, others, 2)
+	assert.Equal(t, "b", others[0].Record.Id)
+	assert.Equal(t, "d", others[1].Record.Id)
+
+	assert.Empty(t, otherGateways(others[:1], "gw2"))
+}
+
+func TestFailoverPrimaries(t *testing.T) {
+
+	app := newTestApp(t)
+	user := saveUser(t, app, "failover@test.local")
+	task := saveTask(t, app, user, "13500001111")
+	saveNumber(t, app, "100", "gw1.local")
+	saveNumber(t, app, "200", "gw2.local")
+
+	for _, strategy := range []string{StrategyRoundRobin, StrategyLRU} {
+		dial := &config.Dial{Failover: config.Failover{Attempts: 2}}
+		dial.Caller.Strategy = strategy
+
+		// the failover attempts do not move the strategy on, the chosen callers take turns
+		primaries := map[string]int{}
+		for range 6 {
+			results, err := makeCall(app, dialConf{dial: dial}, user, task.Id, "")
+			assert.Nil(t, err)
+			assert.Len(t, results, 2)
+			assert.NotEqual(t, results[0].OriCaller, results[1].OriCaller)
+			primaries[results[0].OriCaller]++
+		}
+		assert.Equal(t, map[string]int{"100": 3, "200": 3}, primaries, strategy)
+	}
+}
+
+// dialConf is a config of the dial config only, the others fail to load as if not set.
+type dialConf struct {
+	config.Provider
+	dial *config.Dial
+}
+
+func (c dialConf) Dial() (*config.Dial, error) { return c.dial, nil }
+
+func (c dialConf) CallingHours() (*config.CallingHours, error) {
+	return nil, errors.New("calling hours not set")
+}
@@ -2,10 +2,16 @@ package call
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"text/template"
+	"time"
 
+	"github.com/tcmzzz/lightcall/server/callstate"
+	"github.com/tcmzzz/lightcall/server/config"
+
+	"github.com/pkg/errors"
 	"github.com/pocketbase/pocketbase/core"
 )
 
@@ -16,103 +22,272 @@ type fsCallForm struct {
 	AuthToken  string `json:"variable_sip_i_ring_auth"`
 }
 
-func HandleFsCall(se *core.RequestEvent) error {
+func HandleFsCall(conf config.Provider) func(*core.RequestEvent) error {
 
-	app, form := se.App, &fsCallForm{}
+	return func(se *core.RequestEvent) error {
+		app, form := se.App, &fsCallForm{}
 
-	if err := se.BindBody(form); err != nil {
-		app.Logger().Error("bind req fail", "err", err)
-		return se.String(200, fsFmtFailTpl(400, "Invalid Request", app.Logger()))
-	}
+		if err := se.BindBody(form); err != nil {
+			app.Logger().Error("bind req fail", "err", err)
+			return se.String(200, fsFailTpl(ErrInvalidRequest, app.Logger()))
+		}
 
-	// verify auth and number
-	user, err := se.App.FindAuthRecordByToken(form.AuthToken, core.TokenTypeAuth)
+		// verify auth and number
+		user, err := se.App.FindAuthRecordByToken(form.AuthToken, core.TokenTypeAuth)
 
-	if err != nil {
-		app.Logger().Error("find auth fail", "err", err)
-		return se.String(200, fsFmtFailTpl(401, "Unauthorized", app.Logger()))
+		if err != nil {
+			app.Logger().Error("find auth fail", "err", err)
+			return se.String(200, fsFailTpl(ErrUnauthorized, app.Logger()))
+		}
+
+		if user.Id != form.UserID {
+			app.Logger().Error("user id not same", "form", form.UserID, "record", user.Id)
+			return se.String(200, fsFailTpl(ErrUnauthorized, app.Logger()))
+		}
+
+		activity, err := app.FindRecordById("activity", form.ActivityID)
+		if err != nil {
+			app.Logger().Error("can not find activity", "form", form.ActivityID)
+			return se.String(200, fsFailTpl(ErrActivityNotFound, app.Logger()))
+		}
+
+		// the activity is written below, it must be the user's own of the task dialed
+		if err := checkActivity(activity, user.Id, form.TaskID); err != nil {
+			app.Logger().Error("activity not of the call", "activity", activity.Id, "err", err)
+			return se.String(200, fsFailTpl(ErrForbidden, app.Logger()))
+		}
+
+		// an activity is dialed once, right after it is created
+		if activity.GetString("state") != callstate.Created || !callstate.Transit(activity, callstate.Dialing) {
+			app.Logger().Error("activity already dialed", "activity", activity.Id, "state", activity.GetString("state"))
+			return se.String(200, fsFailTpl(ErrActivityDialed, app.Logger()))
+		}
+
+		// dial the callers chosen when the activity was created, a second pick would
+		// move the ordered strategies on and dial another number than rawlog.call
+		results, err := replanCall(app, conf, user, form.TaskID, activity)
+		if err != nil {
+			ce := asCallError(err)
+			app.Logger().Warn("make call fail", "activity", form.ActivityID, "code", ce.Code(), "err", err)
+			if ce.Failure != ErrNotOwner {
+				if err := saveFail(app, activity, form.TaskID, ce); err != nil {
+					app.Logger().Error("save call fail", "activity", activity.Id, "err", err)
+				}
+			}
+			return se.String(200, fsFailTpl(ce.Failure, app.Logger()))
+		}
+
+		recording := planRecording(app, conf, objectiveOf(app, form.TaskID), recordingKeys{
+			Activity: activity.Id,
+			Task:     form.TaskID,
+			User:     user.Id,
+			Time:     time.Now(),
+		})
+
+		// keep the attempts, cdr tells which one connected, and the recording file for the cdr
+		if err := setRawlog(activity, map[string]any{"call": results[0], "attempts": results, "recording": recording}); err != nil {
+			app.Logger().Warn("save call attempts fail", "activity", activity.Id, "err", err)
+		}
+		if err := app.Save(activity); err != nil {
+			app.Logger().Warn("save call attempts fail", "activity", activity.Id, "err", err)
+		}
+
+		causes := []string{}
+		if dial, err := conf.Dial(); err != nil {
+			app.Logger().Warn("failed to load dial config, failover disabled", "err", err)
+		} else {
+			causes = dial.Failover.Causes
+		}
+
+		// Format template
+		param := fsTplBridgeParam{
+			UserID:         form.UserID,
+			TaskID:         form.TaskID,
+			ActivityID:     form.ActivityID,
+			OriCallee:      results[0].OriCallee,
+			ContinueOnFail: continueOnFail(causes),
+			RecordFile:     recording.File,
+			RecordStereo:   recording.Stereo,
+		}
+		tpl := ""
+		for i, result := range results {
+			gw, err := app.FindRecordById("outgw", result.Gateway)
+			if err != nil {
+				app.Logger().Warn("can not find outgw of attempt", "outgw", result.Gateway, "err", err)
+				gw = nil
+			}
+			attempt := fsTplAttempt{
+				Attempt:   i + 1,
+				OriCaller: result.OriCaller,
+				Caller:    result.Caller,
+				Callee:    result.Callee,
+				DialStr:   fmt.Sprintf("%s@%s", result.Callee, result.Addr),
+				Gateway:   result.Gateway,
+				Options:   gatewayOptions(app, gw),
+			}
+			// the dialplan is of the gateway chosen
+			if i == 0 {
+				param.Gateway, param.Options = attempt.Gateway, attempt.Options
+				if gw != nil {
+					tpl = gw.GetString("dialplan")
+				}
+			}
+			param.Attempts = append(param.Attempts, attempt)
+		}
+
+		dialplan, err := param.Fmt(tpl)
+		if err != nil {
+			ce := failf(ErrDialplanInvalid, "dialplan of %s: %v", param.Gateway, err)
+			app.Logger().Error("format dialplan fail", "outgw", param.Gateway, "err", err)
+			if err := saveFail(app, activity, form.TaskID, ce); err != nil {
+				app.Logger().Error("save call fail", "activity", activity.Id, "err", err)
+			}
+			return se.String(200, fsFailTpl(ce.Failure, app.Logger()))
+		}
+
+		return se.String(200, dialplan)
 	}
+}
 
-	if user.Id != form.UserID {
-		app.Logger().Error("user id not same", "form", form.UserID, "record", user.Id)
-		return se.String(200, fsFmtFailTpl(401, "Unauthorized", app.Logger()))
+// checkActivity fails with ErrForbidden unless the activity was created by the user for the task.
+// The task of the activities created before the relation is in rawlog.taskId.
+func checkActivity(activity *core.Record, userID, taskID string) error {
+	if owner := activity.GetString("user"); owner != userID {
+		return failf(ErrForbidden, "activity of user %s, not %s", owner, userID)
 	}
 
-	if _, err := app.FindRecordById("activity", form.ActivityID); err != nil {
-		app.Logger().Error("can not find activity", "form", form.ActivityID)
-		return se.String(200, fsFmtFailTpl(400, "Invalid Request", app.Logger()))
+	task := activity.GetString("task")
+	if task == "" {
+		rawlog := struct {
+			TaskID string `json:"taskId"`
+		}{}
+		_ = json.Unmarshal([]byte(activity.GetString("rawlog")), &rawlog)
+		task = rawlog.TaskID
+	}
+	if task != taskID {
+		return failf(ErrForbidden, "activity of task %s, not %s", task, taskID)
 	}
+	return nil
+}
 
-	result, err := makeCall(app, user, form.TaskID)
-	if err != nil {
-		app.Logger().Error("make call fail", "err", err)
-		return se.String(200, fsFmtFailTpl(400, "Invalid Request", app.Logger()))
+// plannedAttempts are the attempts kept in rawlog when the activity was created, nil when none.
+// They tell which callers were chosen, replanCall checks and builds them again before dialing.
+func plannedAttempts(activity *core.Record) []*Result {
+	rawlog := struct {
+		Attempts []*Result `json:"attempts"`
+	}{}
+	if err := json.Unmarshal([]byte(activity.GetString("rawlog")), &rawlog); err != nil {
+		return nil
+	}
+	return rawlog.Attempts
+}
+
+// setRawlog sets the values in rawlog of the activity, keeping the others. The activity is not saved.
+func setRawlog(activity *core.Record, values map[string]any) error {
+	str := activity.GetString("rawlog")
+	if str == "" {
+		str = "{}"
 	}
 
-	// Format template
-	param := fsTplBridgeParam{
-		UserID:     form.UserID,
-		TaskID:     form.TaskID,
-		ActivityID: form.ActivityID,
-		OriCaller:  result.OriCaller,
-		OriCallee:  result.OriCallee,
-		Caller:     result.Caller,
-		Callee:     result.Callee,
-		DialStr:    fmt.Sprintf("%s@%s", result.Callee, result.Addr),
+	rawlog := map[string]any{}
+	if err := json.Unmarshal([]byte(str), &rawlog); err != nil {
+		return err
+	}
+	for k, v := range values {
+		rawlog[k] = v
 	}
 
-	return se.String(200, param.Fmt(app.Logger()))
+	rl, err := json.Marshal(rawlog)
+	if err != nil {
+		return err
+	}
+	activity.Set("rawlog", string(rl))
+	return nil
 }
 
+// fsTplBridgeParam is the data of the dialplan template, the default one is fsTplBridge.
 type fsTplBridgeParam struct {
-	UserID     string
-	TaskID     string
-	ActivityID string
-	OriCaller  string
-	OriCallee  string
-	Caller     string
-	Callee     string
-	DialStr    string
+	UserID         string
+	TaskID         string
+	ActivityID     string
+	OriCallee      string
+	ContinueOnFail string // hangup causes on which the next attempt is bridged
+	RecordFile     string // recording file relative to the recordings dir, empty when not recorded
+	RecordStereo   bool
+	Gateway        string         // outgw id of the first attempt, whose dialplan is used
+	Options        map[string]any // options of the outgw of the first attempt
+	Attempts       []fsTplAttempt
 }
 
-func (p fsTplBridgeParam) Fmt(logger *slog.Logger) string {
+// fsTplAttempt is one bridge of the dialplan, Attempt counts from 1.
+type fsTplAttempt struct {
+	Attempt   int
+	OriCaller string
+	Caller    string
+	Callee    string
+	DialStr   string
+	Gateway   string         // outgw id of the attempt
+	Options   map[string]any // options of the outgw of the attempt
+}
+
+// Fmt renders the dialplan template tpl, fsTplBridge when tpl is empty.
+func (p fsTplBridgeParam) Fmt(tpl string) (string, error) {
+	t, err := parseDialplan(tpl)
+	if err != nil {
+		return "", err
+	}
 
 	buf := bytes.NewBufferString("")
-	t := template.Must(template.New("bridge").Parse(fsTplBridge))
 	if err := t.Execute(buf, p); err != nil {
-		logger.Warn("format tpl fail", "err", err)
+		return "", errors.Wrap(err, "render dialplan")
 	}
-	return buf.String()
+	return buf.String(), nil
 }
 
 // <action application="answer"/>
 const fsTplBridge = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
  <document type="freeswitch/xml">
-  <section name="dialplan" description=""">
+  <section name="dialplan" description="">
    <context name="public">
     <extension name="hold_music" continue="true">
       <condition>
-        <action application="set" data="userId={{.UserID}}" />
-        <action application="set" data="taskId={{.TaskID}}"/>
-        <action application="set" data="activityId={{.ActivityID}}"/>
-        <action application="set" data="oriCaller={{.OriCaller}}"/>
-        <action application="set" data="oriCallee={{.OriCallee}}"/>
-        <action application="set" data="realCaller={{.Caller}}"/>
-        <action application="set" data="realCallee={{.Callee}}"/>
-        <action application="set" data="effective_caller_id_name={{.Caller}}"/>
-        <action application="set" data="effective_caller_id_number={{.Caller}}"/>
-        <action application="set" data="RECORD_STEREO=true"/>
+        <action application="set" data="userId={{xml .UserID}}" />
+        <action application="set" data="taskId={{xml .TaskID}}"/>
+        <action application="export" data="activityId={{xml .ActivityID}}"/>
+        <action application="set" data="oriCallee={{xml .OriCallee}}"/>
+        {{- with index .Attempts 0}}
+        <action application="set" data="effective_caller_id_number={{xml .Caller}}"/>
+        {{- end}}
+        {{- if .RecordFile}}
+        <action application="set" data="RECORD_STEREO={{.RecordStereo}}"/>
         <action application="set" data="RECORD_DATE=${strftime(%Y-%m-%d %H:%M)}"/>
-        <action application="set" data="record_file=${strftime(%Y-%m-%d-%H-%M-%S)}_${destination_number}_${effective_caller_id_number}.mp3"/>
+        <action application="set" data="record_file={{xml .RecordFile}}"/>
         <action application="record_session" data="$${recordings_dir}/${record_file}"/>
+        {{- end}}
         <action application="set" data="hangup_after_bridge=true"/>
-        <action application="bridge" data="sofia/internal/{{.DialStr}}"/>
+        {{- if .ContinueOnFail}}
+        <action application="set" data="continue_on_fail={{xml .ContinueOnFail}}"/>
+        {{- end}}
+        {{- range .Attempts}}
+        <action application="set" data="lc_attempt={{.Attempt}}"/>
+        <action application="set" data="oriCaller={{xml .OriCaller}}"/>
+        <action application="set" data="realCaller={{xml .Caller}}"/>
+        <action application="set" data="realCallee={{xml .Callee}}"/>
+        <action application="set" data="effective_caller_id_name={{xml .Caller}}"/>
+        <action application="set" data="effective_caller_id_number={{xml .Caller}}"/>
+        <action application="bridge" data="{lc_attempt={{.Attempt}}}sofia/internal/{{xml .DialStr}}"/>
+        {{- end}}
       </condition>
     </extension>
    </context>
   </section>
 </document>`
 
+// fsFailTpl is the fail dialplan responding the SIP response of the failure.
+func fsFailTpl(f *Failure, logger *slog.Logger) string {
+	return fsFmtFailTpl(f.SipCode, f.SipMsg, logger)
+}
+
 func fsFmtFailTpl(code int, msg string, logger *slog.Logger) string {
 
 	buf := bytes.NewBufferString("")
@@ -131,7 +306,7 @@ func fsFmtFailTpl(code int, msg string, logger *slog.Logger) string {
 
 const fsTplFail = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
  <document type="freeswitch/xml">
-  <section name="dialplan" description=""">
+  <section name="dialplan" description="">
    <context name="public">
     <extension name="dialFail">
       <condition>
@@ -0,0 +1,132 @@
+package call
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tcmzzz/lightcall/server/callstate"
+	"github.com/tcmzzz/lightcall/server/config"
+
+	"github.com/pkg/errors"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckActivity(t *testing.T) {
+
+	activity := func(user, task, rawlog string) *core.Record {
+		r := core.NewRecord(core.NewBaseCollection("activity"))
+		r.Set("user", user)
+		r.Set("task", task)
+		r.Set("rawlog", rawlog)
+		return r
+	}
+
+	assert.Nil(t, checkActivity(activity("u1", "t1", `{"taskId":"t1"}`), "u1", "t1"))
+	// created before the task relation
+	assert.Nil(t, checkActivity(activity("u1", "", `{"taskId":"t1"}`), "u1", "t1"))
+
+	// another agent's activity, or of another task
+	assert.True(t, errors.Is(checkActivity(activity("u2", "t1", ""), "u1", "t1"), ErrForbidden))
+	assert.True(t, errors.Is(checkActivity(activity("u1", "t2", `{"taskId":"t1"}`), "u1", "t1"), ErrForbidden))
+	assert.True(t, errors.Is(checkActivity(activity("u1", "", `{"taskId":"t2"}`), "u1", "t1"), ErrForbidden))
+	assert.True(t, errors.Is(checkActivity(activity("u1", "", ""), "u1", "t1"), ErrForbidden))
+}
+
+// createActivity creates the call activity of the task as the agent does, returning its id.
+func createActivity(t *testing.T, app core.App, conf config.Provider, user *core.Record, taskID string) string {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.SetPathValue("id", taskID)
+	rec := httptest.NewRecorder()
+	e := &core.RequestEvent{App: app, Auth: user}
+	e.Request, e.Response = req, rec
+	if err := HandleCreateActivity(conf)(e); err != nil {
+		t.Fatal(err)
+	}
+
+	ret := map[string]string{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &ret); err != nil {
+		t.Fatal(err)
+	}
+	return ret["id"]
+}
+
+// fsCall asks for the dialplan of the activity as FreeSWITCH does, returning the dialplan.
+func fsCall(t *testing.T, app core.App, conf config.Provider, user *core.Record, taskID, activityID string) string {
+	t.Helper()
+
+	token, err := user.NewAuthToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(fsCallForm{TaskID: taskID, ActivityID: activityID, UserID: user.Id, AuthToken: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	e := &core.RequestEvent{App: app}
+	e.Request, e.Response = req, rec
+	if err := HandleFsCall(conf)(e); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Body.String()
+}
+
+func TestHandleFsCall(t *testing.T) {
+
+	app := newTestApp(t)
+	conf := config.New(app)
+	user := saveUser(t, app, "fs@test.local")
+	task := saveTask(t, app, user, "13500001111")
+	number := saveNumber(t, app, "100", "gw1.local")
+
+	// the kept attempts only tell the number chosen, they are built again from the records
+	id := createActivity(t, app, conf, user, task.Id)
+	activity, err := app.FindRecordById("activity", id)
+	assert.Nil(t, err)
+	assert.Nil(t, setRawlog(activity, map[string]any{"attempts": []*Result{{
+		OriCaller: "100", Caller: "666666", Callee: `1"/><action application="bridge" data="sofia/external/1`,
+		Addr: "evil.local", Gateway: number.GetString("outgw"),
+	}}}))
+	assert.Nil(t, app.Save(activity))
+
+	dialplan := fsCall(t, app, conf, user, task.Id, id)
+	assert.Contains(t, dialplan, "sofia/internal/13500001111@gw1.local")
+	assert.NotContains(t, dialplan, "evil.local")
+	assert.NotContains(t, dialplan, "666666")
+	activity, err = app.FindRecordById("activity", id)
+	assert.Nil(t, err)
+	assert.Equal(t, callstate.Dialing, activity.GetString("state"))
+
+	// dialed once
+	assert.Contains(t, fsCall(t, app, conf, user, task.Id, id), "403 Activity Already Dialed")
+
+	// the number chosen was disabled since, another is chosen
+	id = createActivity(t, app, conf, user, task.Id)
+	saveNumber(t, app, "200", "gw2.local")
+	number.Set("enable", false)
+	assert.Nil(t, app.Save(number))
+	assert.Contains(t, fsCall(t, app, conf, user, task.Id, id), "sofia/internal/13500001111@gw2.local")
+	activity, err = app.FindRecordById("activity", id)
+	assert.Nil(t, err)
+	assert.Contains(t, activity.GetString("rawlog"), `"OriCaller":"200"`)
+
+	// the calling hours are checked again
+	id = createActivity(t, app, conf, user, task.Id)
+	now := time.Now().UTC()
+	saveConfig(t, app, "calling_hours", config.CallingHours{Enable: true, Timezone: "UTC", Holidays: []string{
+		now.Add(-time.Hour).Format(time.DateOnly), now.Add(time.Hour).Format(time.DateOnly),
+	}})
+	assert.Contains(t, fsCall(t, app, conf, user, task.Id, id), "403 Outside Calling Hours")
+	activity, err = app.FindRecordById("activity", id)
+	assert.Nil(t, err)
+	assert.Equal(t, callstate.Failed, activity.GetString("state"))
+}
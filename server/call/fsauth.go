@@ -0,0 +1,128 @@
+package call
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tcmzzz/lightcall/server/config"
+
+	"github.com/pkg/errors"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	fsTimestampHeader = "X-Lightcall-Timestamp"
+	fsSignatureHeader = "X-Lightcall-Signature"
+
+	defaultFsSkew = 300
+)
+
+// defaultFreeSwitch is used when the freeswitch config can not be loaded, only local and private networks are allowed.
+var defaultFreeSwitch = config.FreeSwitch{
+	Allow: []string{"127.0.0.1/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"},
+}
+
+// fsRejected counts the rejected FreeSWITCH requests since start.
+var fsRejected atomic.Int64
+
+// RequireFreeSwitch only lets the xml_curl requests of FreeSWITCH through:
+// the client ip must be in the allow list, and the body signed when a secret is set.
+func RequireFreeSwitch(conf config.Provider) func(*core.RequestEvent) error {
+
+	return func(se *core.RequestEvent) error {
+		app := se.App
+
+		fs, err := conf.FreeSwitch()
+		if err != nil {
+			app.Logger().Warn("failed to load freeswitch config, use default", "err", err)
+			fs = &defaultFreeSwitch
+		}
+
+		ip := se.RealIP()
+		if !ipAllowed(fs.Allow, ip) {
+			return rejectFs(se, ip, "ip not allowed")
+		}
+
+		if fs.Secret != "" {
+			body, err := io.ReadAll(se.Request.Body)
+			if err != nil {
+				return rejectFs(se, ip, "read body: "+err.Error())
+			}
+			skew := time.Duration(fs.Skew) * time.Second
+			if fs.Skew <= 0 {
+				skew = defaultFsSkew * time.Second
+			}
+			err = checkFsSign(fs.Secret, se.Request.Header.Get(fsTimestampHeader), se.Request.Header.Get(fsSignatureHeader), body, time.Now(), skew)
+			if err != nil {
+				return rejectFs(se, ip, err.Error())
+			}
+		}
+
+		return se.Next()
+	}
+}
+
+func rejectFs(se *core.RequestEvent, ip, reason string) error {
+	n := fsRejected.Add(1)
+	se.App.Logger().Warn("reject freeswitch request", "ip", ip, "path", se.Request.URL.Path, "reason", reason, "rejected", n)
+	return se.String(http.StatusForbidden, fsTplNotFound)
+}
+
+// ipAllowed tells whether ip is one of the IPs or CIDRs of allow. An empty allow list allows any ip.
+func ipAllowed(allow []string, ip string) bool {
+	if len(allow) == 0 {
+		return true
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, a := range allow {
+		a = strings.TrimSpace(a)
+		if strings.Contains(a, "/") {
+			if _, n, err := net.ParseCIDR(a); err == nil && n.Contains(addr) {
+				return true
+			}
+		} else if allowed := net.ParseIP(a); allowed != nil && allowed.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// fsSign is the signature of a request: hex of HMAC-SHA256 over "<timestamp>.<body>".
+func fsSign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkFsSign verifies the signature and that the unix timestamp is within skew of now.
+func checkFsSign(secret, timestamp, signature string, body []byte, now time.Time, skew time.Duration) error {
+	if timestamp == "" || signature == "" {
+		return errors.New("no signature")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.Errorf("invalid timestamp %q", timestamp)
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > skew || d < -skew {
+		return errors.Errorf("timestamp %d out of skew", ts)
+	}
+
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(fsSign(secret, timestamp, body))) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
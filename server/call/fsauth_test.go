@@ -0,0 +1,39 @@
+package call
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPAllowed(t *testing.T) {
+
+	assert.True(t, ipAllowed(nil, "1.2.3.4"))
+
+	allow := []string{"192.168.66.13", "10.0.0.0/8", "::1/128", "bad", "1.1.1.0/33"}
+	assert.True(t, ipAllowed(allow, "192.168.66.13"))
+	assert.True(t, ipAllowed(allow, "10.20.30.40"))
+	assert.True(t, ipAllowed(allow, "::1"))
+	assert.False(t, ipAllowed(allow, "192.168.66.14"))
+	assert.False(t, ipAllowed(allow, "1.1.1.1"))
+	assert.False(t, ipAllowed(allow, ""))
+}
+
+func TestCheckFsSign(t *testing.T) {
+
+	now := time.Unix(1700000000, 0)
+	body := []byte("section=dialplan&variable_sip_i_ring_taskid=t1")
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sig := fsSign("s3cret", ts, body)
+
+	assert.Nil(t, checkFsSign("s3cret", ts, sig, body, now, time.Minute))
+	assert.Nil(t, checkFsSign("s3cret", ts, sig, body, now.Add(59*time.Second), time.Minute))
+
+	assert.Error(t, checkFsSign("s3cret", "", "", body, now, time.Minute))
+	assert.Error(t, checkFsSign("s3cret", "x", sig, body, now, time.Minute))
+	assert.Error(t, checkFsSign("s3cret", ts, sig, body, now.Add(2*time.Minute), time.Minute))
+	assert.Error(t, checkFsSign("other", ts, sig, body, now, time.Minute))
+	assert.Error(t, checkFsSign("s3cret", ts, sig, []byte("section=dialplan"), now, time.Minute))
+}
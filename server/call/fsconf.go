@@ -0,0 +1,157 @@
+package call
+
+import (
+	"bytes"
+	"encoding/xml"
+	"log/slog"
+	"sort"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// defaultGatewayProfile is the sofia profile of an outgw whose options.profile is not set.
+const defaultGatewayProfile = "external"
+
+// fsConfForm is the xml_curl request of the configuration section.
+// sofia fetches sofia.conf with profile set when a profile is rescanned.
+type fsConfForm struct {
+	Section  string `json:"section" form:"section"`
+	KeyValue string `json:"key_value" form:"key_value"`
+	Profile  string `json:"profile" form:"profile"`
+}
+
+// HandleFsConfiguration answers the xml_curl configuration requests of FreeSWITCH.
+// On a profile rescan sofia.conf is answered with the gateways of the enabled outgw in
+// that profile. Any other configuration, including the full sofia.conf loaded on start,
+// is not found so FreeSWITCH keeps its static config.
+func HandleFsConfiguration(se *core.RequestEvent) error {
+	app, form := se.App, &fsConfForm{}
+
+	if err := se.BindBody(form); err != nil {
+		app.Logger().Error("bind req fail", "err", err)
+		return se.String(200, fsTplNotFound)
+	}
+
+	if form.Section != "configuration" || form.KeyValue != "sofia.conf" || form.Profile == "" {
+		return se.String(200, fsTplNotFound)
+	}
+
+	records, err := app.FindAllRecords("outgw", dbx.HashExp{"enable": true})
+	if err != nil {
+		app.Logger().Error("find outgw fail", "err", err)
+		return se.String(200, fsTplNotFound)
+	}
+
+	gateways := make([]fsGateway, 0, len(records))
+	for _, r := range records {
+		opts := outgwOptions{}
+		if err := r.UnmarshalJSONField("options", &opts); err != nil && r.GetString("options") != "" {
+			app.Logger().Warn("failed to parse outgw options", "outgw", r.Id, "err", err)
+		}
+		if profileOf(opts) != form.Profile {
+			continue
+		}
+
+		gw, err := fsGatewayOf(r.Id, r.GetString("addr"), opts)
+		if err != nil {
+			app.Logger().Warn("skip outgw in sofia.conf", "outgw", r.Id, "err", err)
+			continue
+		}
+		gateways = append(gateways, gw)
+	}
+	sort.Slice(gateways, func(i, j int) bool { return gateways[i].Name < gateways[j].Name })
+
+	return se.String(200, fsFmtSofia(form.Profile, gateways, app.Logger()))
+}
+
+// fsGateway is a gateway of sofia.conf, named by the outgw id.
+type fsGateway struct {
+	Name     string
+	Proxy    string
+	Register bool
+	Username string
+	Password string
+}
+
+func profileOf(opts outgwOptions) string {
+	if opts.Profile == "" {
+		return defaultGatewayProfile
+	}
+	return opts.Profile
+}
+
+// fsGatewayOf is the sofia gateway of an outgw. A registering gateway needs its credentials.
+func fsGatewayOf(id, addr string, opts outgwOptions) (fsGateway, error) {
+	if addr == "" {
+		return fsGateway{}, errors.New("no addr")
+	}
+	if opts.Registry && (opts.Username == "" || opts.Password == "") {
+		return fsGateway{}, errors.New("registry needs username and password")
+	}
+	return fsGateway{
+		Name:     id,
+		Proxy:    addr,
+		Register: opts.Registry,
+		Username: opts.Username,
+		Password: opts.Password,
+	}, nil
+}
+
+func fsFmtSofia(profile string, gateways []fsGateway, logger *slog.Logger) string {
+
+	buf := bytes.NewBufferString("")
+	t := template.Must(template.New("sofia").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(fsTplSofia))
+	err := t.Execute(buf, map[string]any{
+		"Profile":  profile,
+		"Gateways": gateways,
+	})
+	if err != nil {
+		logger.Warn("format tpl fail", "error", err)
+	}
+	return buf.String()
+}
+
+// xmlEscape escapes s for the text or an attribute of XML.
+func xmlEscape(s string) string {
+	buf := bytes.NewBufferString("")
+	_ = xml.EscapeText(buf, []byte(s))
+	return buf.String()
+}
+
+const fsTplSofia = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<document type="freeswitch/xml">
+  <section name="configuration" description="">
+    <configuration name="sofia.conf" description="lightcall gateways">
+      <profiles>
+        <profile name="{{xml .Profile}}">
+          <gateways>
+          {{- range .Gateways}}
+            <gateway name="{{xml .Name}}">
+              <param name="proxy" value="{{xml .Proxy}}"/>
+              <param name="realm" value="{{xml .Proxy}}"/>
+              <param name="register" value="{{.Register}}"/>
+              {{- if .Username}}
+              <param name="username" value="{{xml .Username}}"/>
+              {{- end}}
+              {{- if .Password}}
+              <param name="password" value="{{xml .Password}}"/>
+              {{- end}}
+              <param name="caller-id-in-from" value="true"/>
+            </gateway>
+          {{- end}}
+          </gateways>
+        </profile>
+      </profiles>
+    </configuration>
+  </section>
+</document>`
+
+const fsTplNotFound = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<document type="freeswitch/xml">
+  <section name="result">
+    <result status="not found"/>
+  </section>
+</document>`
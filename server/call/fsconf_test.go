@@ -0,0 +1,46 @@
+package call
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFsGateway(t *testing.T) {
+
+	gw, err := fsGatewayOf("gw1", "1.2.3.4:5060", outgwOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, fsGateway{Name: "gw1", Proxy: "1.2.3.4:5060"}, gw)
+
+	gw, err = fsGatewayOf("gw2", "sip.example.com", outgwOptions{Registry: true, Username: "u", Password: "p"})
+	assert.Nil(t, err)
+	assert.Equal(t, fsGateway{Name: "gw2", Proxy: "sip.example.com", Register: true, Username: "u", Password: "p"}, gw)
+
+	_, err = fsGatewayOf("gw3", "sip.example.com", outgwOptions{Registry: true, Password: "p"})
+	assert.Error(t, err)
+	_, err = fsGatewayOf("gw4", "", outgwOptions{})
+	assert.Error(t, err)
+
+	assert.Equal(t, "external", profileOf(outgwOptions{}))
+	assert.Equal(t, "carrier", profileOf(outgwOptions{Profile: "carrier"}))
+}
+
+func TestFsFmtSofia(t *testing.T) {
+
+	out := fsFmtSofia("external", []fsGateway{
+		{Name: "gw1", Proxy: "1.2.3.4:5060"},
+		{Name: "gw2", Proxy: "sip.example.com", Register: true, Username: "u", Password: `p"<&`},
+	}, nil)
+
+	assert.Nil(t, wellFormed(out))
+	assert.Equal(t, 2, strings.Count(out, "<gateway "))
+	assert.Contains(t, out, `<profile name="external">`)
+	assert.Contains(t, out, `<param name="register" value="true"/>`)
+	assert.Contains(t, out, `<param name="password" value="p&#34;&lt;&amp;"/>`)
+	// no credentials for a gateway without them
+	assert.Equal(t, 1, strings.Count(out, `name="username"`))
+
+	assert.Nil(t, wellFormed(fsFmtSofia("external", nil, nil)))
+	assert.Nil(t, wellFormed(fsTplNotFound))
+}
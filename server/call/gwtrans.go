@@ -0,0 +1,58 @@
+package call
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// gwTrans is the compiled transcaller/transcallee of an outgw as of updated.
+type gwTrans struct {
+	updated string
+	caller  []TransItem
+	callee  []TransItem
+}
+
+var (
+	gwTransMu sync.Mutex
+	// gwTransCache keeps the compiled rules of each outgw, keyed by outgw id.
+	gwTransCache = map[string]*gwTrans{}
+)
+
+// transOf returns the compiled transcaller and transcallee of the outgw,
+// they are compiled again only after the outgw is updated.
+func transOf(app core.App, gw *core.Record) (caller, callee []TransItem) {
+	updated := gw.GetString("updated")
+
+	gwTransMu.Lock()
+	defer gwTransMu.Unlock()
+
+	if c, ok := gwTransCache[gw.Id]; ok && c.updated == updated {
+		return c.caller, c.callee
+	}
+
+	c := &gwTrans{
+		updated: updated,
+		caller:  parseGwTrans(app, gw, "transcaller"),
+		callee:  parseGwTrans(app, gw, "transcallee"),
+	}
+	gwTransCache[gw.Id] = c
+	return c.caller, c.callee
+}
+
+// parseGwTrans parses the rules of the outgw field. Rules saved before they were
+// checked on save may be invalid, they are then used as is like they used to be.
+func parseGwTrans(app core.App, gw *core.Record, field string) []TransItem {
+	trans, err := ParseTrans(gw.GetString(field))
+	if err == nil {
+		return trans
+	}
+	app.Logger().Error("failed to parse "+field, "outgw", gw.Id, "err", err)
+
+	trans = make([]TransItem, 0)
+	if err := json.Unmarshal([]byte(gw.GetString(field)), &trans); err != nil {
+		app.Logger().Error("failed to parse "+field, "outgw", gw.Id, "err", err)
+	}
+	return trans
+}
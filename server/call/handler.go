@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/tcmzzz/lightcall/server/callstate"
 	precall "github.com/tcmzzz/lightcall/server/cloud/precall"
 	"github.com/tcmzzz/lightcall/server/config"
 
@@ -11,42 +12,82 @@ import (
 	"github.com/pocketbase/pocketbase/core"
 )
 
-func HandleCreateActivity(e *core.RequestEvent) error {
-	taskID := e.Request.PathValue("id")
-	user := e.Auth
+func HandleCreateActivity(conf config.Provider) func(*core.RequestEvent) error {
 
-	_, err := e.App.FindRecordById("task", taskID)
-	if err != nil {
-		return e.BadRequestError("invalid task", err)
-	}
+	return func(e *core.RequestEvent) error {
+		taskID := e.Request.PathValue("id")
+		user := e.Auth
 
-	result, err := makeCall(e.App, user, taskID)
-	if err != nil {
-		return e.InternalServerError("create activity fail", err)
-	}
+		_, err := e.App.FindRecordById("task", taskID)
+		if err != nil {
+			return apiError(failed(ErrTaskNotFound, err))
+		}
 
-	// 创建activity记录
-	c, err := e.App.FindCollectionByNameOrId("activity")
-	if err != nil {
-		return e.InternalServerError("create activity fail", err)
-	}
+		results, callErr := makeCall(e.App, conf, user, taskID, "")
 
-	activity := core.NewRecord(c)
-	rawlog := map[string]any{
-		"call": result,
-	}
-	rawlogBytes, _ := json.Marshal(rawlog)
-	activity.Load(map[string]any{
-		"user":   user.Id,
-		"isCall": true,
-		"rawlog": string(rawlogBytes),
-	})
+		// 创建activity记录, 呼叫失败时也创建, 记录失败原因
+		c, err := e.App.FindCollectionByNameOrId("activity")
+		if err != nil {
+			return e.InternalServerError("create activity fail", err)
+		}
 
-	if err := e.App.Save(activity); err != nil {
-		return e.InternalServerError("create activity fail", err)
+		activity := core.NewRecord(c)
+		rawlog := map[string]any{
+			"taskId": taskID,
+		}
+		// the caller is chosen here once, FreeSWITCH dials the attempts kept in rawlog
+		if callErr == nil {
+			rawlog["call"] = results[0]
+			rawlog["attempts"] = results
+		}
+		rawlogBytes, _ := json.Marshal(rawlog)
+		activity.Load(map[string]any{
+			"user":   user.Id,
+			"task":   taskID,
+			"isCall": true,
+			"state":  callstate.Created,
+			"rawlog": string(rawlogBytes),
+		})
+
+		if callErr != nil {
+			ce := asCallError(callErr)
+			e.App.Logger().Warn("make call fail", "task", taskID, "err", callErr)
+			// not the user's task, nothing to record on it
+			if ce.Failure != ErrNotOwner {
+				if err := saveFail(e.App, activity, taskID, ce); err != nil {
+					e.App.Logger().Error("save call fail", "task", taskID, "err", err)
+				}
+			}
+			return apiError(ce)
+		}
+
+		if err := e.App.Save(activity); err != nil {
+			return e.InternalServerError("create activity fail", err)
+		}
+
+		return e.JSON(http.StatusOK, map[string]string{"id": activity.Id})
 	}
+}
+
+// HandleCallPlan runs the call preparation of a task without creating an activity,
+// to explain which caller would be used and why the others are not.
+func HandleCallPlan(conf config.Provider) func(*core.RequestEvent) error {
 
-	return e.JSON(http.StatusOK, map[string]string{"id": activity.Id})
+	return func(e *core.RequestEvent) error {
+		taskID := e.Request.PathValue("taskId")
+
+		if _, err := e.App.FindRecordById("task", taskID); err != nil {
+			return e.NotFoundError("Task not found", err)
+		}
+
+		plan, err := planCall(e.App, conf, e.Auth, taskID, "", true)
+		if err != nil {
+			plan.Error = err.Error()
+			plan.Code = asCallError(err).Code()
+		}
+
+		return e.JSON(http.StatusOK, plan)
+	}
 }
 
 func HandlePreCall(conf config.Provider, handler *precall.Handler) func(*core.RequestEvent) error {
@@ -60,7 +101,8 @@ func HandlePreCall(conf config.Provider, handler *precall.Handler) func(*core.Re
 
 		// 解析rawlog.call
 		var rawlog struct {
-			Call struct{ Caller, Callee string }
+			TaskID string `json:"taskId"`
+			Call   struct{ Caller, Callee string }
 		}
 		if err := json.Unmarshal([]byte(activity.GetString("rawlog")), &rawlog); err != nil {
 			return e.BadRequestError("Invalid rawlog format", err)
@@ -102,6 +144,15 @@ func HandlePreCall(conf config.Provider, handler *precall.Handler) func(*core.Re
 			return e.InternalServerError("Failed to update activity", err)
 		}
 
+		// 被拦截时记录失败原因
+		if !result.Pass {
+			ce := failf(ErrPreCallBlocked, "%s: %s", handler.Name, result.Msg)
+			result.Code = ce.Code()
+			if err := saveFail(e.App, activity, rawlog.TaskID, ce); err != nil {
+				e.App.Logger().Error("save call fail", "activity", activity.Id, "err", err)
+			}
+		}
+
 		return e.JSON(http.StatusOK, result)
 	}
 }
@@ -188,3 +239,51 @@ func HandleDirectCall(e *core.RequestEvent) error {
 	// 返回任务ID
 	return e.JSON(http.StatusOK, map[string]string{"taskId": task.Id})
 }
+
+// HandleTransPreview runs sample numbers through transform rules and shows every step.
+// The rules are the ones posted, or the saved field of the outgw when none is posted.
+func HandleTransPreview(e *core.RequestEvent) error {
+	var req struct {
+		Gateway string          `json:"gateway"` // outgw id
+		Field   string          `json:"field"`   // transcaller or transcallee
+		Trans   json.RawMessage `json:"trans"`   // rules being edited
+		Numbers []string        `json:"numbers"`
+	}
+	if err := json.NewDecoder(e.Request.Body).Decode(&req); err != nil {
+		return e.BadRequestError("Invalid JSON", err)
+	}
+
+	raw := string(req.Trans)
+	if len(req.Trans) == 0 || raw == "null" {
+		if req.Field != "transcaller" && req.Field != "transcallee" {
+			return e.BadRequestError("field must be transcaller or transcallee", nil)
+		}
+		gw, err := e.App.FindRecordById("outgw", req.Gateway)
+		if err != nil {
+			return e.NotFoundError("Gateway not found", err)
+		}
+		raw = gw.GetString(req.Field)
+	}
+
+	trans, err := ParseTrans(raw)
+	if err != nil {
+		return e.BadRequestError(err.Error(), nil)
+	}
+
+	type preview struct {
+		Number string      `json:"number"`
+		Result string      `json:"result"`
+		Steps  []TransStep `json:"steps"`
+	}
+	ret := make([]preview, 0, len(req.Numbers))
+	for _, n := range req.Numbers {
+		steps := TraceTrans(n, trans)
+		result := n
+		if len(steps) > 0 {
+			result = steps[len(steps)-1].Output
+		}
+		ret = append(ret, preview{Number: n, Result: result, Steps: steps})
+	}
+
+	return e.JSON(http.StatusOK, ret)
+}
@@ -0,0 +1,91 @@
+package call
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tcmzzz/lightcall/server/config"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+)
+
+// callPlan asks for the call plan of the task as the agent does.
+func callPlan(t *testing.T, app core.App, conf config.Provider, user *core.Record, taskID string) *Plan {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetPathValue("taskId", taskID)
+	rec := httptest.NewRecorder()
+	e := &core.RequestEvent{App: app, Auth: user}
+	e.Request, e.Response = req, rec
+	if err := HandleCallPlan(conf)(e); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	plan := &Plan{}
+	if err := json.Unmarshal(rec.Body.Bytes(), plan); err != nil {
+		t.Fatal(err)
+	}
+	return plan
+}
+
+func TestHandleCallPlan(t *testing.T) {
+
+	app := newTestApp(t)
+	saveConfig(t, app, "dial", map[string]any{
+		"caller":   map[string]any{"strategy": StrategyRoundRobin},
+		"failover": map[string]any{"attempts": 2},
+	})
+	conf := config.New(app)
+	user := saveUser(t, app, "plan@test.local")
+	task := saveTask(t, app, user, "13500001111")
+
+	saveNumber(t, app, "100", "gw1.local")
+	saveNumber(t, app, "200", "gw2.local")
+	disabled := saveNumber(t, app, "300", "gw3.local")
+	disabled.Set("enable", false)
+	assert.Nil(t, app.Save(disabled))
+
+	plan := callPlan(t, app, conf, user, task.Id)
+	assert.Empty(t, plan.Error)
+	assert.NotNil(t, plan.Result)
+	assert.Contains(t, []string{"100", "200"}, plan.Result.OriCaller)
+	assert.Equal(t, "13500001111", plan.Result.Callee)
+
+	// the chosen number first, failover on the other gateway
+	assert.Len(t, plan.Attempts, 2)
+	assert.Equal(t, plan.Result, plan.Attempts[0])
+	assert.NotEqual(t, plan.Attempts[0].Gateway, plan.Attempts[1].Gateway)
+
+	assert.Equal(t, []Rejection{{Number: "300", Gateway: disabled.GetString("outgw"), Reason: "number disabled"}}, plan.Rejected)
+
+	// planning again shows the same number, the round-robin is not moved on
+	assert.Equal(t, plan.Result, callPlan(t, app, conf, user, task.Id).Result)
+
+	// the call dials the number of the plan, and moves the round-robin on
+	id := createActivity(t, app, conf, user, task.Id)
+	activity, err := app.FindRecordById("activity", id)
+	assert.Nil(t, err)
+	call := plannedAttempts(activity)
+	assert.NotEmpty(t, call)
+	assert.Equal(t, plan.Result.OriCaller, call[0].OriCaller)
+	assert.NotEqual(t, plan.Result.OriCaller, callPlan(t, app, conf, user, task.Id).Result.OriCaller)
+
+	// a failed plan tells why
+	disabled.Set("enable", true)
+	assert.Nil(t, app.Save(disabled))
+	for _, number := range []string{"100", "200", "300"} {
+		r, err := app.FindFirstRecordByData("number", "number", number)
+		assert.Nil(t, err)
+		r.Set("enable", false)
+		assert.Nil(t, app.Save(r))
+	}
+	plan = callPlan(t, app, conf, user, task.Id)
+	assert.Nil(t, plan.Result)
+	assert.Equal(t, ErrNoCaller.Code, plan.Code)
+	assert.Len(t, plan.Rejected, 3)
+}
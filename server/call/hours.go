@@ -0,0 +1,112 @@
+package call
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tcmzzz/lightcall/server/config"
+
+	"github.com/pkg/errors"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+var weekdayNames = []string{"", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+func outsideHours(format string, args ...any) error {
+	return failf(ErrOutsideCallingHours, format, args...)
+}
+
+// checkHours refuses the call when the calling hours config does not allow it now.
+// The calling hours are not enforced when the config can not be loaded.
+func checkHours(app core.App, conf config.Provider, objective *core.Record) error {
+	hours, err := conf.CallingHours()
+	if err != nil {
+		app.Logger().Warn("failed to load calling hours config, not enforced", "err", err)
+		return nil
+	}
+
+	objectiveID := ""
+	if objective != nil {
+		objectiveID = objective.Id
+	}
+	return checkCallingHours(hours, objectiveID, time.Now())
+}
+
+// checkCallingHours returns an error with cause ErrOutsideCallingHours when a call
+// of the objective is not allowed at now, and a plain error on invalid config.
+func checkCallingHours(hours *config.CallingHours, objectiveID string, now time.Time) error {
+	if hours == nil || !hours.Enable {
+		return nil
+	}
+
+	if hours.Timezone != "" {
+		loc, err := time.LoadLocation(hours.Timezone)
+		if err != nil {
+			return errors.Wrapf(err, "invalid calling hours timezone: %s", hours.Timezone)
+		}
+		now = now.In(loc)
+	}
+
+	rule := config.HoursRule{Windows: hours.Windows, Holidays: hours.Holidays}
+	if o, ok := hours.Objectives[objectiveID]; ok {
+		if len(o.Windows) > 0 {
+			rule.Windows = o.Windows
+		}
+		rule.Holidays = append(slices.Clone(rule.Holidays), o.Holidays...)
+	}
+
+	date := now.Format(time.DateOnly)
+	if slices.Contains(rule.Holidays, date) {
+		return outsideHours("%s is a holiday", date)
+	}
+
+	if len(rule.Windows) == 0 {
+		return nil
+	}
+
+	weekday := int(now.Weekday())
+	if weekday == 0 {
+		weekday = 7
+	}
+	minute := now.Hour()*60 + now.Minute()
+
+	today := make([]string, 0)
+	for _, w := range rule.Windows {
+		start, err := parseClock(w.Start)
+		if err != nil {
+			return err
+		}
+		end, err := parseClock(w.End)
+		if err != nil {
+			return err
+		}
+		if start >= end {
+			return errors.Errorf("invalid calling hours window: %s-%s", w.Start, w.End)
+		}
+
+		if len(w.Weekdays) > 0 && !slices.Contains(w.Weekdays, weekday) {
+			continue
+		}
+		if minute >= start && minute < end {
+			return nil
+		}
+		today = append(today, fmt.Sprintf("%s-%s", w.Start, w.End))
+	}
+
+	if len(today) == 0 {
+		return outsideHours("no calls allowed on %s", weekdayNames[weekday])
+	}
+	return outsideHours("now %s %s, allowed %s",
+		weekdayNames[weekday], now.Format("15:04"), strings.Join(today, ", "))
+}
+
+// parseClock parses "HH:MM" as minutes of the day, "24:00" is the end of the day.
+func parseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, errors.Errorf("invalid calling hours time: %q", s)
+	}
+	return h*60 + m, nil
+}
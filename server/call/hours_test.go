@@ -0,0 +1,79 @@
+package call
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tcmzzz/lightcall/server/config"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallingHours(t *testing.T) {
+
+	hours := &config.CallingHours{
+		Enable:   true,
+		Timezone: "UTC",
+		Windows: []config.HoursWindow{
+			{Weekdays: []int{1, 2, 3, 4, 5}, Start: "09:00", End: "12:00"},
+			{Weekdays: []int{1, 2, 3, 4, 5}, Start: "14:00", End: "20:00"},
+			{Weekdays: []int{6}, Start: "10:00", End: "18:00"},
+		},
+		Holidays: []string{"2025-10-01"},
+		Objectives: map[string]config.HoursRule{
+			"night": {Windows: []config.HoursWindow{{Start: "18:00", End: "24:00"}}},
+			"busy":  {Holidays: []string{"2025-10-08"}},
+		},
+	}
+
+	at := func(s string) time.Time {
+		tm, err := time.Parse(time.DateTime, s)
+		assert.Nil(t, err)
+		return tm
+	}
+
+	cases := []struct {
+		objective string
+		now       string
+		allow     bool
+	}{
+		{"", "2025-10-08 09:00:00", true},  // Wed
+		{"", "2025-10-08 11:59:59", true},  // Wed
+		{"", "2025-10-08 12:00:00", false}, // Wed, end not included
+		{"", "2025-10-08 23:00:00", false}, // Wed
+		{"", "2025-10-11 10:30:00", true},  // Sat
+		{"", "2025-10-12 10:30:00", false}, // Sun
+		{"", "2025-10-01 10:30:00", false}, // holiday
+		{"night", "2025-10-08 23:00:00", true},
+		{"night", "2025-10-08 10:00:00", false},
+		{"night", "2025-10-01 23:00:00", false}, // default holidays still apply
+		{"busy", "2025-10-08 10:00:00", false},
+		{"busy", "2025-10-09 10:00:00", true},
+	}
+
+	for _, c := range cases {
+		err := checkCallingHours(hours, c.objective, at(c.now))
+		if c.allow {
+			assert.Nil(t, err, c.now)
+		} else {
+			assert.True(t, errors.Is(err, ErrOutsideCallingHours), c.now)
+		}
+	}
+
+	err := checkCallingHours(hours, "", at("2025-10-12 10:30:00"))
+	assert.Equal(t, "outside calling hours: no calls allowed on Sun", err.Error())
+	err = checkCallingHours(hours, "", at("2025-10-08 23:00:00"))
+	assert.Equal(t, "outside calling hours: now Wed 23:00, allowed 09:00-12:00, 14:00-20:00", err.Error())
+
+	// disabled or without windows
+	assert.Nil(t, checkCallingHours(nil, "", at("2025-10-08 23:00:00")))
+	assert.Nil(t, checkCallingHours(&config.CallingHours{}, "", at("2025-10-01 23:00:00")))
+	assert.Nil(t, checkCallingHours(&config.CallingHours{Enable: true}, "", at("2025-10-08 23:00:00")))
+
+	// invalid config is not a calling hours refusal
+	bad := &config.CallingHours{Enable: true, Windows: []config.HoursWindow{{Start: "9", End: "18:00"}}}
+	err = checkCallingHours(bad, "", at("2025-10-08 10:00:00"))
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrOutsideCallingHours))
+}
@@ -0,0 +1,63 @@
+package call
+
+import (
+	"strings"
+
+	"github.com/tcmzzz/lightcall/server/config"
+)
+
+// Mark is a spam label on a caller number, reported by carriers or handsets.
+type Mark struct {
+	From     string `json:"from"`
+	Type     string `json:"type"`
+	Severity string `json:"severity"`
+	Cnt      int    `json:"cnt"`
+}
+
+// applyMarkFilter drops the candidates with an excluded mark and down-weights the penalized ones.
+func applyMarkFilter(filter config.MarkFilter, candidates []*Candidate) ([]*Candidate, []Rejection, error) {
+
+	if len(filter.Exclude) == 0 && len(filter.Penalize) == 0 {
+		return candidates, nil, nil
+	}
+
+	ret := make([]*Candidate, 0, len(candidates))
+	rejected := make([]Rejection, 0)
+	for _, c := range candidates {
+		var mark struct {
+			Mark []Mark `json:"mark"`
+		}
+		if err := c.Record.UnmarshalJSONField("mark", &mark); err != nil {
+			mark.Mark = nil
+		}
+
+		cnt := map[string]int{}
+		for _, m := range mark.Mark {
+			cnt[strings.ToLower(m.Severity)] += max(m.Cnt, 1)
+		}
+
+		excluded := ""
+		for _, severity := range filter.Exclude {
+			if cnt[strings.ToLower(severity)] > 0 {
+				excluded = severity
+				break
+			}
+		}
+		if excluded != "" {
+			rejected = append(rejected, reject(c.Record, "spam mark "+excluded))
+			continue
+		}
+
+		for _, p := range filter.Penalize {
+			if cnt[strings.ToLower(p.Severity)] > p.Over {
+				c.Weight *= p.Weight
+			}
+		}
+		ret = append(ret, c)
+	}
+
+	if len(ret) == 0 {
+		return nil, rejected, failf(ErrNoCaller, "all %d enabled numbers are excluded by spam marks(%s)", len(candidates), strings.Join(filter.Exclude, ","))
+	}
+	return ret, rejected, nil
+}
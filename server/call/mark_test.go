@@ -0,0 +1,45 @@
+package call
+
+import (
+	"testing"
+
+	"github.com/tcmzzz/lightcall/server/config"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func markCandidate(id, mark string) *Candidate {
+	r := core.NewRecord(core.NewBaseCollection("number"))
+	r.Id = id
+	r.Set("mark", mark)
+	return &Candidate{Record: r, Weight: 1}
+}
+
+func TestMarkFilter(t *testing.T) {
+
+	filter := config.MarkFilter{
+		Exclude:  []string{"danger"},
+		Penalize: []config.MarkPenalty{{Severity: "warning", Over: 2, Weight: 0.5}},
+	}
+
+	candidates := []*Candidate{
+		markCandidate("clean", ``),
+		markCandidate("danger", `{"mark":[{"from":"vivo","type":"疑似诈骗","severity":"danger","cnt":2}]}`),
+		markCandidate("warning", `{"mark":[{"from":"vivo","severity":"warning","cnt":2},{"from":"oppo","severity":"warning","cnt":1}]}`),
+		markCandidate("few", `{"mark":[{"from":"vivo","severity":"warning","cnt":2}]}`),
+	}
+
+	ret, rejected, err := applyMarkFilter(filter, candidates)
+	assert.Nil(t, err)
+	assert.Len(t, rejected, 1)
+
+	weights := map[string]float64{}
+	for _, c := range ret {
+		weights[c.Record.Id] = c.Weight
+	}
+	assert.Equal(t, map[string]float64{"clean": 1, "warning": 0.5, "few": 1}, weights)
+
+	_, _, err = applyMarkFilter(filter, []*Candidate{markCandidate("danger", `{"mark":[{"severity":"danger"}]}`)})
+	assert.Error(t, err)
+}
@@ -0,0 +1,122 @@
+package call
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tcmzzz/lightcall/server/esl"
+
+	"github.com/google/uuid"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// modes a supervisor joins a call in
+const (
+	MonitorListen  = "listen"  // only listens
+	MonitorWhisper = "whisper" // talks to the agent, the callee does not hear
+	MonitorBarge   = "barge"   // three-way, both hear the supervisor
+)
+
+// monitorVars are the eavesdrop variables of the supervisor's channel of each mode.
+// The agent's a-leg is the channel eavesdropped, the callee is its b-leg.
+var monitorVars = map[string][]string{
+	MonitorListen:  nil,
+	MonitorWhisper: {"eavesdrop_whisper_aleg=true"},
+	MonitorBarge:   {"eavesdrop_bridge_aleg=true", "eavesdrop_bridge_bleg=true"},
+}
+
+type monitorForm struct {
+	Mode string `json:"mode"`
+}
+
+// HandleMonitor calls the WebRTC extension of the admin and eavesdrops the call of the activity.
+// Each session is saved in monitor, failed or not.
+func HandleMonitor(client *esl.Client) func(*core.RequestEvent) error {
+
+	return func(se *core.RequestEvent) error {
+		app, user := se.App, se.Auth
+
+		if !user.GetBool("isAdmin") {
+			return apiError(failf(ErrForbidden, "user %s is not admin", user.Id))
+		}
+
+		form := &monitorForm{}
+		if err := se.BindBody(form); err != nil {
+			return apiError(failed(ErrInvalidRequest, err))
+		}
+
+		activity, err := app.FindRecordById("activity", se.Request.PathValue("activityId"))
+		if err != nil {
+			return apiError(failed(ErrActivityNotFound, err))
+		}
+
+		channel := uuid.NewString()
+		cmd, err := monitorCmd(form.Mode, user.Id, esl.LiveOf(activity), channel)
+		if err != nil {
+			return apiError(asCallError(err))
+		}
+
+		audit, err := newMonitor(app, activity, user, form.Mode, channel)
+		if err != nil {
+			app.Logger().Error("save monitor fail", "activity", activity.Id, "err", err)
+			return apiError(failed(ErrInternal, err))
+		}
+
+		var ce *CallError
+		if client == nil || !client.Connected() {
+			ce = failf(ErrEslUnavailable, "esl not connected")
+		} else if job, err := client.BgApi(cmd); err != nil {
+			ce = failed(ErrInternal, err)
+		} else {
+			audit.Set("job", job)
+		}
+		if ce != nil {
+			audit.Set("error", ce.Error())
+		}
+		if err := app.Save(audit); err != nil {
+			app.Logger().Error("save monitor fail", "monitor", audit.Id, "err", err)
+		}
+		if ce != nil {
+			return apiError(ce)
+		}
+
+		app.Logger().Info("monitor call", "activity", activity.Id, "user", user.Id, "mode", form.Mode, "monitor", audit.Id)
+		return se.JSON(200, map[string]any{"id": audit.Id, "mode": form.Mode, "uuid": channel})
+	}
+}
+
+func newMonitor(app core.App, activity, user *core.Record, mode, channel string) (*core.Record, error) {
+	c, err := app.FindCollectionByNameOrId("monitor")
+	if err != nil {
+		return nil, err
+	}
+	audit := core.NewRecord(c)
+	audit.Load(map[string]any{
+		"activity": activity.Id,
+		"user":     user.Id,
+		"agent":    activity.GetString("user"),
+		"mode":     mode,
+		"uuid":     channel,
+	})
+	return audit, app.Save(audit)
+}
+
+// monitorCmd originates the channel to the supervisor, which eavesdrops the agent's a-leg once answered.
+func monitorCmd(mode, supervisorID string, live esl.Live, channel string) (string, error) {
+	vars, ok := monitorVars[mode]
+	if !ok {
+		return "", failf(ErrInvalidRequest, "unknown monitor mode %q", mode)
+	}
+	if live.UUID == "" || live.State == esl.LiveHangup {
+		return "", failf(ErrNoChannel, "call not in progress")
+	}
+
+	vars = append([]string{
+		"origination_uuid=" + channel,
+		"origination_caller_id_name=monitor",
+		"origination_caller_id_number=" + mode,
+		"originate_timeout=30",
+		"eavesdrop_enable_dtmf=false",
+	}, vars...)
+	return fmt.Sprintf("originate {%s}user/%s &eavesdrop(%s)", strings.Join(vars, ","), supervisorID, live.UUID), nil
+}
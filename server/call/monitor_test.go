@@ -0,0 +1,36 @@
+package call
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tcmzzz/lightcall/server/esl"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonitorCmd(t *testing.T) {
+
+	live := esl.Live{State: esl.LiveBridged, UUID: "a", BLeg: "b"}
+	base := "origination_uuid=c1,origination_caller_id_name=monitor,origination_caller_id_number=%s,originate_timeout=30,eavesdrop_enable_dtmf=false"
+
+	cmd, err := monitorCmd(MonitorListen, "admin1", live, "c1")
+	assert.Nil(t, err)
+	assert.Equal(t, "originate {"+fmt.Sprintf(base, "listen")+"}user/admin1 &eavesdrop(a)", cmd)
+
+	cmd, err = monitorCmd(MonitorWhisper, "admin1", live, "c1")
+	assert.Nil(t, err)
+	assert.Equal(t, "originate {"+fmt.Sprintf(base, "whisper")+",eavesdrop_whisper_aleg=true}user/admin1 &eavesdrop(a)", cmd)
+
+	cmd, err = monitorCmd(MonitorBarge, "admin1", live, "c1")
+	assert.Nil(t, err)
+	assert.Equal(t, "originate {"+fmt.Sprintf(base, "barge")+",eavesdrop_bridge_aleg=true,eavesdrop_bridge_bleg=true}user/admin1 &eavesdrop(a)", cmd)
+
+	_, err = monitorCmd("spy", "admin1", live, "c1")
+	assert.True(t, errors.Is(err, ErrInvalidRequest))
+	_, err = monitorCmd(MonitorListen, "admin1", esl.Live{}, "c1")
+	assert.True(t, errors.Is(err, ErrNoChannel))
+	_, err = monitorCmd(MonitorListen, "admin1", esl.Live{State: esl.LiveHangup, UUID: "a"}, "c1")
+	assert.True(t, errors.Is(err, ErrNoChannel))
+}
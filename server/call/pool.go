@@ -0,0 +1,102 @@
+package call
+
+import (
+	"slices"
+
+	"github.com/tcmzzz/lightcall/server/region"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// numberPool restricts the caller numbers an objective or a user may use.
+// A number is allowed when it is listed in numbers or its tag matches the tag filter.
+type numberPool struct {
+	owner   string
+	numbers []string
+	tag     region.Region
+}
+
+// poolOf reads the pool of an objective or users record, nil means unrestricted.
+func poolOf(r *core.Record) *numberPool {
+	if r == nil {
+		return nil
+	}
+
+	p := &numberPool{
+		owner:   r.Collection().Name + "(" + r.Id + ")",
+		numbers: r.GetStringSlice("numbers"),
+	}
+	_ = r.UnmarshalJSONField("numberTag", &p.tag)
+
+	if len(p.numbers) == 0 && p.tag.Province == "" && p.tag.City == "" {
+		return nil
+	}
+	return p
+}
+
+func (p *numberPool) allow(number *core.Record) bool {
+
+	if slices.Contains(p.numbers, number.Id) {
+		return true
+	}
+	if p.tag.Province == "" && p.tag.City == "" {
+		return false
+	}
+
+	tag := region.Region{}
+	_ = number.UnmarshalJSONField("tag", &tag)
+
+	if p.tag.Province != "" && !region.SameProvince(p.tag.Province, tag.Province) {
+		return false
+	}
+	if p.tag.City != "" && !region.SameCity(p.tag.City, tag.City) {
+		return false
+	}
+	return true
+}
+
+// applyPools keeps the candidates allowed by every pool, and explains which pool
+// leaves nothing rather than falling back to the global numbers.
+func applyPools(candidates []*Candidate, pools ...*numberPool) ([]*Candidate, []Rejection, error) {
+
+	ret := candidates
+	rejected := make([]Rejection, 0)
+	for _, p := range pools {
+		if p == nil {
+			continue
+		}
+
+		allowed := make([]*Candidate, 0, len(ret))
+		for _, c := range ret {
+			if p.allow(c.Record) {
+				allowed = append(allowed, c)
+			} else {
+				rejected = append(rejected, reject(c.Record, "not in the pool of "+p.owner))
+			}
+		}
+
+		if len(allowed) == 0 {
+			if len(ret) < len(candidates) {
+				return nil, rejected, failf(ErrNoCaller, "no enabled number is allowed by the pools of %s together", ownersOf(pools))
+			}
+			return nil, rejected, failf(ErrNoCaller, "no enabled number in the pool of %s(numbers: %d, tag: %s%s)",
+				p.owner, len(p.numbers), p.tag.Province, p.tag.City)
+		}
+		ret = allowed
+	}
+	return ret, rejected, nil
+}
+
+func ownersOf(pools []*numberPool) string {
+	owners := ""
+	for _, p := range pools {
+		if p == nil {
+			continue
+		}
+		if owners != "" {
+			owners += " and "
+		}
+		owners += p.owner
+	}
+	return owners
+}
@@ -0,0 +1,78 @@
+package call
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func poolCandidate(id, tag string) *Candidate {
+	r := core.NewRecord(core.NewBaseCollection("number"))
+	r.Id = id
+	r.Set("number", id)
+	r.Set("tag", tag)
+	return &Candidate{Record: r, Weight: 1}
+}
+
+// poolRecord is an objective or users record of the collection with its pool.
+func poolRecord(collection, id string, numbers []string, tag string) *core.Record {
+	r := core.NewRecord(core.NewBaseCollection(collection))
+	r.Id = id
+	r.Set("numbers", numbers)
+	r.Set("numberTag", tag)
+	return r
+}
+
+func TestApplyPools(t *testing.T) {
+
+	candidates := []*Candidate{
+		poolCandidate("bj", `{"province":"北京市","city":"北京市"}`),
+		poolCandidate("sz", `{"province":"广东省","city":"深圳市"}`),
+		poolCandidate("gz", `{"province":"广东","city":"广州"}`),
+		poolCandidate("none", ``),
+	}
+
+	cs := []struct {
+		name      string
+		objective *core.Record
+		user      *core.Record
+		expected  []string
+		rejected  int
+		fail      string // message of the ErrNoCaller failure, "" when not failed
+	}{
+		{"no pool", nil, nil, []string{"bj", "sz", "gz", "none"}, 0, ""},
+		{"empty pools", poolRecord("objective", "o1", nil, ``), poolRecord("users", "u1", []string{}, `{"province":"","city":""}`),
+			[]string{"bj", "sz", "gz", "none"}, 0, ""},
+		{"objective numbers", poolRecord("objective", "o1", []string{"sz", "none"}, ``), nil, []string{"sz", "none"}, 2, ""},
+		{"user tag", nil, poolRecord("users", "u1", nil, `{"province":"广东省"}`), []string{"sz", "gz"}, 2, ""},
+		{"user city", nil, poolRecord("users", "u1", nil, `{"province":"广东","city":"广州市"}`), []string{"gz"}, 3, ""},
+		{"numbers or tag", poolRecord("objective", "o1", []string{"bj"}, `{"city":"深圳"}`), nil, []string{"bj", "sz"}, 2, ""},
+		{"both pools", poolRecord("objective", "o1", []string{"bj", "sz"}, ``), poolRecord("users", "u1", nil, `{"province":"广东省"}`),
+			[]string{"sz"}, 3, ""},
+		{"objective leaves none", poolRecord("objective", "o1", []string{"other"}, ``), poolRecord("users", "u1", []string{"bj"}, ``),
+			nil, 4, "no caller available: no enabled number in the pool of objective(o1)(numbers: 1, tag: )"},
+		{"user leaves none", nil, poolRecord("users", "u1", nil, `{"province":"上海市"}`),
+			nil, 4, "no caller available: no enabled number in the pool of users(u1)(numbers: 0, tag: 上海市)"},
+		{"none together", poolRecord("objective", "o1", []string{"bj"}, ``), poolRecord("users", "u1", nil, `{"province":"广东省"}`),
+			nil, 4, "no caller available: no enabled number is allowed by the pools of objective(o1) and users(u1) together"},
+	}
+
+	for _, c := range cs {
+		ret, rejected, err := applyPools(candidates, poolOf(c.objective), poolOf(c.user))
+		assert.Len(t, rejected, c.rejected, c.name)
+		if c.fail != "" {
+			assert.True(t, errors.Is(err, ErrNoCaller), c.name)
+			assert.EqualError(t, err, c.fail, c.name)
+			assert.Nil(t, ret, c.name)
+			continue
+		}
+		assert.Nil(t, err, c.name)
+		ids := []string{}
+		for _, r := range ret {
+			ids = append(ids, r.Record.Id)
+		}
+		assert.Equal(t, c.expected, ids, c.name)
+	}
+}
@@ -0,0 +1,156 @@
+package call
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/tcmzzz/lightcall/server/callstate"
+	"github.com/tcmzzz/lightcall/server/config"
+
+	"github.com/pkg/errors"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	defaultAbandonTimeout = 30 * time.Minute
+	// reapBatch is the most activities abandoned in one run, the rest wait for the next.
+	reapBatch = 100
+)
+
+// reasons a call activity is abandoned
+const (
+	AbandonNotDialed = "not_dialed" // FreeSWITCH never asked for the dialplan, the browser closed or the INVITE was rejected
+	AbandonNoCdr     = "no_cdr"     // dialed, but the cdr never arrived
+)
+
+var abandonLabels = map[string]string{
+	AbandonNotDialed: "未拨出",
+	AbandonNoCdr:     "未收到话单",
+}
+
+// reapStates are the states of the calls the reaper takes, an answered call may last longer than the timeout.
+var reapStates = []any{callstate.Created, callstate.Dialing, callstate.Ringing}
+
+// MustRegisterReaper runs ReapAbandoned every minute with the cron of the app.
+func MustRegisterReaper(app core.App, conf config.Provider) {
+	app.Cron().MustAdd("reapAbandonedCalls", "* * * * *", func() {
+		n, err := ReapAbandoned(app, conf, time.Now())
+		if err != nil {
+			app.Logger().Error("reap abandoned calls fail", "err", err)
+		}
+		if n > 0 {
+			app.Logger().Info("reap abandoned calls", "count", n)
+		}
+	})
+}
+
+// ReapAbandoned marks the call activities created before the timeout and still without a cdr abandoned,
+// and relates them to their task as the cdr does, so the agent sees them and the activity event is appended.
+// It returns the number of activities abandoned.
+func ReapAbandoned(app core.App, conf config.Provider, now time.Time) (int, error) {
+	dial, err := conf.Dial()
+	if err != nil {
+		app.Logger().Warn("load dial config fail, use the default abandon timeout", "err", err)
+		dial = &config.Dial{}
+	}
+	timeout := abandonTimeout(dial.Abandon)
+	if timeout == 0 {
+		return 0, nil
+	}
+
+	cutoff, err := types.ParseDateTime(now.Add(-timeout))
+	if err != nil {
+		return 0, err
+	}
+
+	activities := []*core.Record{}
+	err = app.RecordQuery("activity").
+		AndWhere(dbx.NewExp("[[isCall]] = TRUE AND [[created]] < {:cutoff}", dbx.Params{"cutoff": cutoff.String()})).
+		AndWhere(dbx.NewExp("json_extract([[rawlog]], '$.fslega') IS NULL AND json_extract([[rawlog]], '$.fail') IS NULL")).
+		AndWhere(dbx.In("state", reapStates...)).
+		OrderBy("created ASC").
+		Limit(reapBatch).
+		All(&activities)
+	if err != nil {
+		return 0, errors.Wrap(err, "find abandoned calls fail")
+	}
+
+	n := 0
+	for _, activity := range activities {
+		if err := abandon(app, activity); err != nil {
+			app.Logger().Warn("abandon call fail", "activity", activity.Id, "err", err)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+// abandonTimeout is the timeout of the config in minutes, 0 when the reaper is off.
+func abandonTimeout(minutes int) time.Duration {
+	switch {
+	case minutes < 0:
+		return 0
+	case minutes == 0:
+		return defaultAbandonTimeout
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// abandonReason tells whether FreeSWITCH dialed the call by its state.
+func abandonReason(state string) string {
+	switch state {
+	case callstate.Dialing, callstate.Ringing:
+		return AbandonNoCdr
+	}
+	return AbandonNotDialed
+}
+
+// abandon records the reason in rawlog.abandon, tells it in the comment and relates the activity to its task.
+// The task is the relation of the activity, or rawlog.taskId for the activities created before it.
+func abandon(app core.App, activity *core.Record) error {
+	rawlog := map[string]any{}
+	if str := activity.GetString("rawlog"); str != "" {
+		if err := json.Unmarshal([]byte(str), &rawlog); err != nil {
+			return errors.Wrap(err, "activity rawlog invalid")
+		}
+	}
+
+	reason := abandonReason(activity.GetString("state"))
+	if !callstate.Transit(activity, callstate.Abandoned) {
+		return nil
+	}
+	if err := setRawlog(activity, map[string]any{"abandon": map[string]string{"reason": reason, "message": abandonLabels[reason]}}); err != nil {
+		return err
+	}
+	activity.Set("comment", fmt.Sprintf("呼叫放弃(%s)", abandonLabels[reason]))
+
+	taskID := activity.GetString("task")
+	if taskID == "" {
+		taskID, _ = rawlog["taskId"].(string)
+	}
+	var task *core.Record
+	if taskID != "" {
+		var err error
+		if task, err = app.FindRecordById("task", taskID); err != nil {
+			app.Logger().Warn("task of abandoned call not found", "activity", activity.Id, "task", taskID)
+			task = nil
+		} else {
+			activity.Set("task", task.Id)
+		}
+	}
+
+	return app.RunInTransaction(func(txApp core.App) error {
+		if err := txApp.Save(activity); err != nil {
+			return err
+		}
+		if task == nil {
+			return nil
+		}
+		task.Set("activity+", activity.Id)
+		return txApp.Save(task)
+	})
+}
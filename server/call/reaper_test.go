@@ -0,0 +1,24 @@
+package call
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tcmzzz/lightcall/server/callstate"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAbandonTimeout(t *testing.T) {
+
+	assert.Equal(t, 30*time.Minute, abandonTimeout(0))
+	assert.Equal(t, 5*time.Minute, abandonTimeout(5))
+	assert.Equal(t, time.Duration(0), abandonTimeout(-1))
+}
+
+func TestAbandonReason(t *testing.T) {
+
+	assert.Equal(t, AbandonNotDialed, abandonReason(callstate.Created))
+	assert.Equal(t, AbandonNoCdr, abandonReason(callstate.Dialing))
+	assert.Equal(t, AbandonNoCdr, abandonReason(callstate.Ringing))
+}
@@ -0,0 +1,95 @@
+package call
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tcmzzz/lightcall/server/config"
+
+	"github.com/pkg/errors"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Recording is how a call is recorded, saved in rawlog.recording.
+// File is relative to the recordings dir of FreeSWITCH, empty when the call is not recorded.
+type Recording struct {
+	File   string `json:"file"`
+	Stereo bool   `json:"stereo"`
+}
+
+const defaultRecordingFilename = "{date}/{activity}"
+
+// defaultRecording is used when the recording config can not be loaded, as calls were always recorded.
+var defaultRecording = config.Recording{Enable: true, Format: "mp3", Stereo: true}
+
+// recordingNameRe is the characters allowed in a recording filename once the keys are filled.
+var recordingNameRe = regexp.MustCompile(`^[0-9A-Za-z_\-./]+$`)
+
+// recordingKeys fill the filename pattern of a recording.
+type recordingKeys struct {
+	Activity string
+	Task     string
+	User     string
+	Time     time.Time
+}
+
+// planRecording decides how the call is recorded by the recording config.
+func planRecording(app core.App, conf config.Provider, objective *core.Record, keys recordingKeys) Recording {
+	rec, err := conf.Recording()
+	if err != nil {
+		app.Logger().Warn("failed to load recording config, use default", "err", err)
+		rec = &defaultRecording
+	}
+
+	objectiveID := ""
+	if objective != nil {
+		objectiveID = objective.Id
+	}
+
+	ret, err := recordingOf(rec, objectiveID, keys)
+	if err != nil {
+		app.Logger().Warn("invalid recording filename, use default", "filename", rec.Filename, "err", err)
+		fixed := *rec
+		fixed.Filename = ""
+		ret, _ = recordingOf(&fixed, objectiveID, keys)
+	}
+	return ret
+}
+
+// recordingOf applies the recording config to a call of the objective.
+// The objective's rule overrides whether to record, and the filename is keyed by the activity.
+func recordingOf(rec *config.Recording, objectiveID string, keys recordingKeys) (Recording, error) {
+	enable := rec.Enable
+	if rule, ok := rec.Objectives[objectiveID]; ok && objectiveID != "" {
+		enable = rule.Enable
+	}
+	if !enable {
+		return Recording{}, nil
+	}
+
+	format := rec.Format
+	if format != "wav" {
+		format = "mp3"
+	}
+
+	pattern := rec.Filename
+	if pattern == "" {
+		pattern = defaultRecordingFilename
+	}
+	if !strings.Contains(pattern, "{activity}") {
+		return Recording{}, errors.Errorf("recording filename %q has no {activity}", pattern)
+	}
+
+	name := strings.NewReplacer(
+		"{activity}", keys.Activity,
+		"{task}", keys.Task,
+		"{user}", keys.User,
+		"{date}", keys.Time.Format("2006-01-02"),
+	).Replace(pattern)
+	if !recordingNameRe.MatchString(name) || strings.Contains(name, "..") || strings.HasPrefix(name, "/") {
+		return Recording{}, errors.Errorf("invalid recording filename %q", name)
+	}
+
+	return Recording{File: name + "." + format, Stereo: rec.Stereo}, nil
+}
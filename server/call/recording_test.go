@@ -0,0 +1,46 @@
+package call
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tcmzzz/lightcall/server/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecording(t *testing.T) {
+
+	keys := recordingKeys{
+		Activity: "act001",
+		Task:     "task001",
+		User:     "user001",
+		Time:     time.Date(2025, 3, 4, 10, 0, 0, 0, time.UTC),
+	}
+
+	cs := []struct {
+		rec       config.Recording
+		objective string
+		expected  Recording
+	}{
+		{config.Recording{}, "", Recording{}},
+		{defaultRecording, "", Recording{File: "2025-03-04/act001.mp3", Stereo: true}},
+		{config.Recording{Enable: true, Format: "wav"}, "", Recording{File: "2025-03-04/act001.wav"}},
+		{config.Recording{Enable: true, Format: "ogg", Filename: "{user}/{task}_{activity}"}, "", Recording{File: "user001/task001_act001.mp3"}},
+		// objectives override both ways
+		{config.Recording{Enable: true, Objectives: map[string]config.RecordingRule{"obj1": {Enable: false}}}, "obj1", Recording{}},
+		{config.Recording{Enable: true, Objectives: map[string]config.RecordingRule{"obj1": {Enable: false}}}, "obj2", Recording{File: "2025-03-04/act001.mp3"}},
+		{config.Recording{Enable: false, Objectives: map[string]config.RecordingRule{"obj1": {Enable: true}}}, "obj1", Recording{File: "2025-03-04/act001.mp3"}},
+	}
+
+	for _, c := range cs {
+		r, err := recordingOf(&c.rec, c.objective, keys)
+		assert.Nil(t, err, c.rec)
+		assert.Equal(t, c.expected, r, c.rec)
+	}
+
+	for _, filename := range []string{"{date}", "../{activity}", "/tmp/{activity}", "{activity} x"} {
+		_, err := recordingOf(&config.Recording{Enable: true, Filename: filename}, "", keys)
+		assert.Error(t, err, filename)
+	}
+}
@@ -0,0 +1,322 @@
+package call
+
+import (
+	"maps"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tcmzzz/lightcall/server/config"
+	"github.com/tcmzzz/lightcall/server/region"
+	"github.com/tcmzzz/lightcall/server/stats"
+
+	"github.com/pkg/errors"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	StrategyRandom     = "random"
+	StrategyRoundRobin = "roundrobin"
+	StrategyLRU        = "lru"
+	StrategyConnect    = "connectrate"
+	StrategyAffinity   = "affinity"
+)
+
+// Candidate is a usable caller number with its outgw expanded.
+// Weight is 1 unless the number is penalized, e.g. by spam marks.
+type Candidate struct {
+	Record *core.Record
+	Weight float64
+}
+
+// Number returns the caller number of the candidate.
+func (c *Candidate) Number() string { return c.Record.GetString("number") }
+
+// CallerStrategy picks one caller number out of the candidates, which are never empty.
+// Random picks honor the weights, ordered strategies only consider the highest weight.
+type CallerStrategy interface {
+	Pick(app core.App, callee string, candidates []*Candidate) (*Candidate, error)
+}
+
+// NewCallerStrategy builds the strategy configured in dial.caller.
+// dial.caller.region narrows the candidates by region before the base strategy picks,
+// and the legacy dial.caller.affinity switch wraps the result with affinity.
+func NewCallerStrategy(dial *config.Dial) CallerStrategy {
+	return newCallerStrategy(dial, false)
+}
+
+// newCallerStrategy builds the strategy configured in dial.caller. With peek the ordered strategies
+// are copies of the shared ones, picking on them tells the next number without moving them on.
+func newCallerStrategy(dial *config.Dial, peek bool) CallerStrategy {
+
+	name, params := dial.Caller.Strategy, dial.Caller.Params
+
+	if name == StrategyAffinity {
+		name = params.Fallback
+	}
+
+	s := baseStrategy(name, params, peek)
+	if dial.Caller.Region {
+		s = &regionStrategy{next: s}
+	}
+	if dial.Caller.Affinity || dial.Caller.Strategy == StrategyAffinity {
+		s = &affinityStrategy{days: params.AffinityDays, fallback: s}
+	}
+	return s
+}
+
+func baseStrategy(name string, params config.StrategyParams, peek bool) CallerStrategy {
+	switch name {
+	case StrategyRoundRobin:
+		if peek {
+			return roundRobin.peek()
+		}
+		return roundRobin
+	case StrategyLRU:
+		if peek {
+			return lru.peek()
+		}
+		return lru
+	case StrategyConnect:
+		s := &connectRateStrategy{window: 24 * time.Hour, explore: 0.05}
+		if params.Window > 0 {
+			s.window = time.Duration(params.Window) * time.Hour
+		}
+		if params.Explore > 0 {
+			s.explore = params.Explore
+		}
+		return s
+	default:
+		return &randomStrategy{}
+	}
+}
+
+var (
+	roundRobin = &roundRobinStrategy{}
+	lru        = &lruStrategy{used: map[string]time.Time{}}
+)
+
+type randomStrategy struct{}
+
+func (s *randomStrategy) Pick(_ core.App, _ string, candidates []*Candidate) (*Candidate, error) {
+
+	total := 0.0
+	for _, c := range candidates {
+		total += c.Weight
+	}
+
+	rd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	if total <= 0 {
+		return candidates[rd.Intn(len(candidates))], nil
+	}
+
+	n := rd.Float64() * total
+	for _, c := range candidates {
+		if n -= c.Weight; n < 0 {
+			return c, nil
+		}
+	}
+	return candidates[len(candidates)-1], nil
+}
+
+// connectRateStrategy weights the candidates by the recent connect rate of the number
+// and the delivery rate of its outgw, never below the exploration floor.
+type connectRateStrategy struct {
+	window  time.Duration
+	explore float64
+}
+
+func (s *connectRateStrategy) Pick(app core.App, callee string, candidates []*Candidate) (*Candidate, error) {
+
+	since := time.Now().Add(-s.window)
+
+	weighted := make([]*Candidate, 0, len(candidates))
+	for _, c := range candidates {
+		rate := stats.Numbers.Rate(c.Number(), since) * stats.Gateways.Rate(c.Record.GetString("outgw"), since)
+		weighted = append(weighted, &Candidate{Record: c.Record, Weight: c.Weight * max(rate, s.explore)})
+	}
+
+	return (&randomStrategy{}).Pick(app, callee, weighted)
+}
+
+// roundRobinStrategy cycles through the candidates ordered by id.
+// The cursor is kept in memory and restarts from zero on reboot.
+type roundRobinStrategy struct {
+	cursor atomic.Uint64
+}
+
+// peek is a copy of the strategy at its cursor.
+func (s *roundRobinStrategy) peek() *roundRobinStrategy {
+	p := &roundRobinStrategy{}
+	p.cursor.Store(s.cursor.Load())
+	return p
+}
+
+func (s *roundRobinStrategy) Pick(_ core.App, _ string, candidates []*Candidate) (*Candidate, error) {
+	sorted := sortByID(heaviest(candidates))
+	n := s.cursor.Add(1) - 1
+	return sorted[n%uint64(len(sorted))], nil
+}
+
+// lruStrategy picks the number that has not been used for the longest time.
+// Last use comes from the activity collection, refined by picks made in this process.
+type lruStrategy struct {
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+// peek is a copy of the strategy with the picks made so far.
+func (s *lruStrategy) peek() *lruStrategy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &lruStrategy{used: maps.Clone(s.used)}
+}
+
+func (s *lruStrategy) Pick(app core.App, _ string, candidates []*Candidate) (*Candidate, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		ret    *Candidate
+		oldest time.Time
+	)
+	for _, c := range sortByID(heaviest(candidates)) {
+		number := c.Number()
+		last := s.used[number]
+
+		activities, err := app.FindRecordsByFilter(
+			"activity",
+			"isCall = true && rawlog.call.OriCaller = {:number}",
+			"-created",
+			1,
+			0,
+			dbx.Params{"number": number},
+		)
+		if err == nil && len(activities) > 0 && activities[0].GetDateTime("created").Time().After(last) {
+			last = activities[0].GetDateTime("created").Time()
+		}
+
+		if ret == nil || last.Before(oldest) {
+			ret, oldest = c, last
+		}
+	}
+
+	s.used[ret.Number()] = time.Now()
+	return ret, nil
+}
+
+// affinityStrategy reuses the number last used to call the callee,
+// as long as it is still among the candidates.
+type affinityStrategy struct {
+	days     int
+	fallback CallerStrategy
+}
+
+func (s *affinityStrategy) Pick(app core.App, callee string, candidates []*Candidate) (*Candidate, error) {
+
+	number, err := s.lastCaller(app, callee)
+	if err != nil {
+		app.Logger().Warn("failed to find affinity caller", "callee", callee, "err", err)
+	}
+
+	if number != "" {
+		for _, c := range candidates {
+			if c.Number() == number {
+				return c, nil
+			}
+		}
+	}
+
+	return s.fallback.Pick(app, callee, candidates)
+}
+
+func (s *affinityStrategy) lastCaller(app core.App, callee string) (string, error) {
+
+	filter := "isCall = true && rawlog.call.OriCallee = {:callee} && rawlog.call.OriCaller != ''"
+	params := dbx.Params{"callee": callee}
+	if s.days > 0 {
+		filter += " && created >= {:since}"
+		params["since"] = time.Now().AddDate(0, 0, -s.days).UTC().Format(time.DateTime)
+	}
+
+	activities, err := app.FindRecordsByFilter("activity", filter, "-created", 1, 0, params)
+	if err != nil {
+		return "", err
+	}
+	if len(activities) == 0 {
+		return "", nil
+	}
+
+	var rawlog struct {
+		Call struct{ OriCaller string }
+	}
+	if err := activities[0].UnmarshalJSONField("rawlog", &rawlog); err != nil {
+		return "", errors.Wrapf(err, "invalid activity rawlog(activity_id: %s)", activities[0].Id)
+	}
+	return rawlog.Call.OriCaller, nil
+}
+
+// regionStrategy prefers numbers tagged with the callee's city, then province,
+// then numbers without a region, and only then the rest of the country.
+type regionStrategy struct {
+	next CallerStrategy
+}
+
+func (s *regionStrategy) Pick(app core.App, callee string, candidates []*Candidate) (*Candidate, error) {
+	return s.next.Pick(app, callee, narrowByRegion(callee, candidates))
+}
+
+func narrowByRegion(callee string, candidates []*Candidate) []*Candidate {
+
+	target, ok := region.Lookup(callee)
+	if !ok {
+		return candidates
+	}
+
+	var city, province, national []*Candidate
+	for _, c := range candidates {
+		tag := region.Region{}
+		_ = c.Record.UnmarshalJSONField("tag", &tag)
+
+		switch {
+		case region.SameProvince(tag.Province, target.Province) && region.SameCity(tag.City, target.City):
+			city = append(city, c)
+		case region.SameProvince(tag.Province, target.Province):
+			province = append(province, c)
+		case tag.Province == "" && tag.City == "":
+			national = append(national, c)
+		}
+	}
+
+	for _, tier := range [][]*Candidate{city, province, national} {
+		if len(tier) > 0 {
+			return tier
+		}
+	}
+	return candidates
+}
+
+// heaviest keeps only the candidates with the highest weight.
+func heaviest(candidates []*Candidate) []*Candidate {
+	var ret []*Candidate
+	for _, c := range candidates {
+		switch {
+		case len(ret) == 0 || c.Weight > ret[0].Weight:
+			ret = []*Candidate{c}
+		case c.Weight == ret[0].Weight:
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+func sortByID(candidates []*Candidate) []*Candidate {
+	sorted := make([]*Candidate, len(candidates))
+	copy(sorted, candidates)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Record.Id < sorted[j].Record.Id })
+	return sorted
+}
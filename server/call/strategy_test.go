@@ -0,0 +1,163 @@
+package call
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tcmzzz/lightcall/server/config"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func numberCandidate(id, number string, weight float64) *Candidate {
+	r := core.NewRecord(core.NewBaseCollection("number"))
+	r.Id = id
+	r.Set("number", number)
+	return &Candidate{Record: r, Weight: weight}
+}
+
+func pickNumbers(t *testing.T, app core.App, s CallerStrategy, callee string, candidates []*Candidate, n int) []string {
+	ret := []string{}
+	for range n {
+		c, err := s.Pick(app, callee, candidates)
+		assert.Nil(t, err)
+		ret = append(ret, c.Number())
+	}
+	return ret
+}
+
+// saveCall saves a call activity of the user from caller to callee, as rawlog.call of a dialed call.
+func saveCall(t *testing.T, app core.App, user *core.Record, caller, callee string, created time.Time) *core.Record {
+	t.Helper()
+
+	c, err := app.FindCollectionByNameOrId("activity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	activity := core.NewRecord(c)
+	activity.Load(map[string]any{
+		"user":   user.Id,
+		"isCall": true,
+		"rawlog": map[string]any{"call": map[string]any{"OriCaller": caller, "OriCallee": callee}},
+	})
+	if err := app.Save(activity); err != nil {
+		t.Fatal(err)
+	}
+	// created is set on save, move it afterwards
+	if _, err := app.DB().NewQuery("UPDATE activity SET created = {:created} WHERE id = {:id}").
+		Bind(map[string]any{"created": created.UTC().Format("2006-01-02 15:04:05.000Z"), "id": activity.Id}).
+		Execute(); err != nil {
+		t.Fatal(err)
+	}
+	return activity
+}
+
+func saveUser(t *testing.T, app core.App, email string) *core.Record {
+	t.Helper()
+
+	c, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := core.NewRecord(c)
+	user.SetEmail(email)
+	user.SetPassword("1234567890")
+	user.Set("name", email)
+	user.Set("active", true)
+	if err := app.Save(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestRandomStrategy(t *testing.T) {
+
+	s := &randomStrategy{}
+
+	// a weight of zero is never picked while another weighs more
+	candidates := []*Candidate{numberCandidate("a", "1", 0), numberCandidate("b", "2", 1)}
+	for _, number := range pickNumbers(t, nil, s, "", candidates, 50) {
+		assert.Equal(t, "2", number)
+	}
+
+	// all of weight zero, picked evenly
+	candidates = []*Candidate{numberCandidate("a", "1", 0), numberCandidate("b", "2", 0)}
+	for _, number := range pickNumbers(t, nil, s, "", candidates, 20) {
+		assert.Contains(t, []string{"1", "2"}, number)
+	}
+
+	// picked by weight
+	candidates = []*Candidate{numberCandidate("a", "1", 0.1), numberCandidate("b", "2", 0.9)}
+	count := map[string]int{}
+	for _, number := range pickNumbers(t, nil, s, "", candidates, 1000) {
+		count[number]++
+	}
+	assert.Greater(t, count["2"], count["1"]*3)
+}
+
+func TestRoundRobinStrategy(t *testing.T) {
+
+	s := &roundRobinStrategy{}
+
+	// ordered by id, whatever the order of the candidates
+	candidates := []*Candidate{numberCandidate("c", "3", 1), numberCandidate("a", "1", 1), numberCandidate("b", "2", 1)}
+	assert.Equal(t, []string{"1", "2", "3", "1"}, pickNumbers(t, nil, s, "", candidates, 4))
+
+	// only the heaviest take turns
+	s = &roundRobinStrategy{}
+	candidates = []*Candidate{numberCandidate("a", "1", 0.5), numberCandidate("b", "2", 1), numberCandidate("c", "3", 1)}
+	assert.Equal(t, []string{"2", "3", "2"}, pickNumbers(t, nil, s, "", candidates, 3))
+}
+
+func TestLruStrategy(t *testing.T) {
+
+	app := newTestApp(t)
+	user := saveUser(t, app, "lru@test.local")
+
+	candidates := []*Candidate{numberCandidate("a", "1", 1), numberCandidate("b", "2", 1), numberCandidate("c", "3", 1)}
+
+	// "1" called an hour ago, "2" a day ago, "3" never
+	saveCall(t, app, user, "1", "100", time.Now().Add(-time.Hour))
+	saveCall(t, app, user, "2", "100", time.Now().Add(-24*time.Hour))
+
+	s := &lruStrategy{used: map[string]time.Time{}}
+	assert.Equal(t, []string{"3", "2", "1", "3"}, pickNumbers(t, app, s, "", candidates, 4))
+
+	// a peek tells the next pick without taking it
+	assert.Equal(t, []string{"2"}, pickNumbers(t, app, s.peek(), "", candidates, 1))
+	assert.Equal(t, []string{"2"}, pickNumbers(t, app, s, "", candidates, 1))
+}
+
+func TestAffinityStrategy(t *testing.T) {
+
+	app := newTestApp(t)
+	user := saveUser(t, app, "affinity@test.local")
+
+	candidates := []*Candidate{numberCandidate("a", "1", 1), numberCandidate("b", "2", 1)}
+	s := &affinityStrategy{fallback: &roundRobinStrategy{}}
+
+	// no call to the callee yet, the fallback picks
+	assert.Equal(t, []string{"1"}, pickNumbers(t, app, s, "100", candidates, 1))
+
+	// the number last used to call the callee
+	saveCall(t, app, user, "1", "100", time.Now().Add(-48*time.Hour))
+	saveCall(t, app, user, "2", "100", time.Now().Add(-time.Hour))
+	assert.Equal(t, []string{"2", "2"}, pickNumbers(t, app, s, "100", candidates, 2))
+
+	// not among the candidates any more
+	assert.Equal(t, []string{"1"}, pickNumbers(t, app, s, "100", candidates[:1], 1))
+}
+
+func TestPeekStrategy(t *testing.T) {
+
+	dial := &config.Dial{}
+	dial.Caller.Strategy = StrategyRoundRobin
+	candidates := []*Candidate{numberCandidate("a", "1", 1), numberCandidate("b", "2", 1)}
+
+	// the plan shows the number the next call dials
+	next := pickNumbers(t, nil, newCallerStrategy(dial, true), "", candidates, 1)
+	assert.Equal(t, next, pickNumbers(t, nil, newCallerStrategy(dial, true), "", candidates, 1))
+	assert.Equal(t, next, pickNumbers(t, nil, NewCallerStrategy(dial), "", candidates, 1))
+	assert.NotEqual(t, next, pickNumbers(t, nil, NewCallerStrategy(dial), "", candidates, 1))
+}
@@ -1,21 +1,211 @@
 package call
 
-import "regexp"
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
 
+	"github.com/tcmzzz/lightcall/server/region"
+
+	"github.com/pkg/errors"
+)
+
+// TransItem is a step transforming a number, its types are listed in transTypes.
+// group applies the first of its items whose condition holds.
+// A step with a condition only applies when the condition holds.
 type TransItem struct {
-	Type  string   `json:"type"`
-	Param []string `json:"param"`
+	Type  string            `json:"type"`
+	Param []string          `json:"param"`
+	When  *TransCond        `json:"when,omitempty"`  // 执行条件, 为空时总是执行
+	Items []TransItem       `json:"items,omitempty"` // group 的子规则, 只执行第一条条件成立的
+	Table map[string]string `json:"table,omitempty"` // lookup 的对照表, 按最长匹配替换号码前缀
+
+	re *regexp.Regexp // compiled Param[0] of replace
 }
 
+// TransCond is the condition of a TransItem, every field set must hold.
+// It is checked on the number as transformed by the steps before.
+type TransCond struct {
+	Match    string `json:"match,omitempty"`    // 号码匹配的正则
+	Class    string `json:"class,omitempty"`    // 号码类型: mobile/landline/short
+	Province string `json:"province,omitempty"` // 号码归属省份
+	City     string `json:"city,omitempty"`     // 号码归属城市
+	Outside  bool   `json:"outside,omitempty"`  // 为 true 时号码归属不在 province/city 才成立, 如外地手机
+
+	re *regexp.Regexp // compiled Match
+}
+
+// TransStep is how a step of the rules went for a number.
+type TransStep struct {
+	Rule    int    `json:"rule"`           // index of the rule, from 0
+	Type    string `json:"type"`           // type of the rule
+	Input   string `json:"input"`          // number before the step
+	Output  string `json:"output"`         // number after the step
+	Applied bool   `json:"applied"`        // whether the condition held
+	Item    *int   `json:"item,omitempty"` // index of the group item applied, nil when none
+	Error   string `json:"error,omitempty"`
+}
+
+// ApplyTrans applies the rules to the number in order, a failed step keeps the number
+// as is and the rest still apply. The error is of the first step failed.
 func ApplyTrans(number string, trans []TransItem) (rn string, re error) {
 	rn = number
 	for _, t := range trans {
-		rn, re = t.applyTrans(rn)
+		var err error
+		if rn, err = t.applyTrans(rn); err != nil && re == nil {
+			re = err
+		}
 	}
 	return
 }
 
+// TraceTrans applies the rules to the number like ApplyTrans, recording every step.
+func TraceTrans(number string, trans []TransItem) []TransStep {
+	steps := make([]TransStep, 0, len(trans))
+	for i, t := range trans {
+		step := TransStep{Rule: i, Type: t.Type, Input: number, Output: number}
+
+		ok, err := t.When.holds(number)
+		if err == nil && ok {
+			step.Applied = true
+			if t.Type == "group" {
+				var item int
+				if item, err = t.match(number); err == nil && item >= 0 {
+					step.Item = &item
+				}
+			}
+			if err == nil {
+				step.Output, err = t.apply(number)
+			}
+		}
+		if err != nil {
+			step.Error = err.Error()
+		}
+
+		number = step.Output
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// ParseTrans parses and checks the rules saved in transcaller/transcallee of outgw,
+// unknown fields are refused. The regexes of the rules returned are compiled.
+func ParseTrans(raw string) ([]TransItem, error) {
+	trans := make([]TransItem, 0)
+	if raw == "" || raw == "null" {
+		return trans, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewBufferString(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&trans); err != nil {
+		return nil, errors.Wrap(err, "invalid rules")
+	}
+	return CompileTrans(trans)
+}
+
+// CompileTrans checks the rules and compiles their regexes, the rules given are not changed.
+func CompileTrans(trans []TransItem) ([]TransItem, error) {
+	ret := make([]TransItem, 0, len(trans))
+	for i, t := range trans {
+		c, err := t.compile()
+		if err != nil {
+			return nil, errors.Wrapf(err, "rule %d", i+1)
+		}
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
+
+func (t TransItem) compile() (TransItem, error) {
+	typ, ok := findTransType(t.Type)
+	if !ok {
+		return t, errors.Errorf("unknown type %q", t.Type)
+	}
+
+	for i, p := range typ.Params {
+		if i >= len(t.Param) {
+			if p.Optional {
+				break
+			}
+			return t, errors.Errorf("%s needs %s", t.Type, countParams(typ.Params))
+		}
+		if p.Pattern != "" && !regexp.MustCompile(p.Pattern).MatchString(t.Param[i]) {
+			return t, errors.Errorf("%s param %s invalid: %q", t.Type, p.Title, t.Param[i])
+		}
+	}
+
+	switch t.Type {
+	case "replace":
+		re, err := regexp.Compile(t.Param[0])
+		if err != nil {
+			return t, errors.Wrapf(err, "invalid regex %q", t.Param[0])
+		}
+		t.re = re
+	case "lookup":
+		if len(t.Table) == 0 {
+			return t, errors.New("lookup needs a table")
+		}
+	case "group":
+		items, err := CompileTrans(t.Items)
+		if err != nil {
+			return t, errors.Wrap(err, "group")
+		}
+		t.Items = items
+	}
+
+	if t.When != nil {
+		when, err := t.When.compile()
+		if err != nil {
+			return t, err
+		}
+		t.When = when
+	}
+	return t, nil
+}
+
+// countParams tells how many params are required, such as "1 param" or "2 params".
+func countParams(ps []transParam) string {
+	n := 0
+	for _, p := range ps {
+		if !p.Optional {
+			n++
+		}
+	}
+	if n == 1 {
+		return "1 param"
+	}
+	return strconv.Itoa(n) + " params"
+}
+
+func (c TransCond) compile() (*TransCond, error) {
+	if c.Match != "" {
+		re, err := regexp.Compile(c.Match)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid condition regex %q", c.Match)
+		}
+		c.re = re
+	}
+
+	switch c.Class {
+	case "", region.ClassMobile, region.ClassLandline, region.ClassShort:
+	default:
+		return nil, errors.Errorf("unknown condition class %q", c.Class)
+	}
+	return &c, nil
+}
+
 func (t TransItem) applyTrans(number string) (string, error) {
+	ok, err := t.When.holds(number)
+	if err != nil || !ok {
+		return number, err
+	}
+	return t.apply(number)
+}
+
+func (t TransItem) apply(number string) (string, error) {
 
 	var re error
 	switch t.Type {
@@ -29,13 +219,126 @@ func (t TransItem) applyTrans(number string) (string, error) {
 		}
 	case "replace":
 		if len(t.Param) > 1 {
-			r, err := regexp.Compile(t.Param[0])
+			r, err := compiled(t.re, t.Param[0])
 			if err != nil {
 				re = err
 				break
 			}
 			return r.ReplaceAllString(number, t.Param[1]), nil
 		}
+	case "strip":
+		if len(t.Param) > 0 {
+			for _, p := range strings.Split(t.Param[0], ",") {
+				if p = strings.TrimSpace(p); p != "" && strings.HasPrefix(number, p) {
+					return number[len(p):], nil
+				}
+			}
+		}
+	case "lookup":
+		key := ""
+		for k := range t.Table {
+			if k != "" && len(k) > len(key) && strings.HasPrefix(number, k) {
+				key = k
+			}
+		}
+		if key != "" {
+			return t.Table[key] + number[len(key):], nil
+		}
+	case "truncate":
+		if len(t.Param) > 0 {
+			n, err := strconv.Atoi(t.Param[0])
+			if err != nil || n < 0 {
+				re = errors.Errorf("invalid truncate length: %q", t.Param[0])
+				break
+			}
+			if len(number) <= n {
+				return number, nil
+			}
+			if len(t.Param) > 1 && t.Param[1] == "tail" {
+				return number[len(number)-n:], nil
+			}
+			return number[:n], nil
+		}
+	case "pad":
+		if len(t.Param) > 0 {
+			n, err := strconv.Atoi(t.Param[0])
+			if err != nil || n < 0 {
+				re = errors.Errorf("invalid pad length: %q", t.Param[0])
+				break
+			}
+			fill := "0"
+			if len(t.Param) > 1 && t.Param[1] != "" {
+				fill = t.Param[1]
+			}
+			if len(number) < n {
+				return strings.Repeat(fill, n-len(number)) + number, nil
+			}
+		}
+	case "group":
+		i, err := t.match(number)
+		if err != nil {
+			return number, err
+		}
+		if i >= 0 {
+			return t.Items[i].apply(number)
+		}
 	}
 	return number, re
 }
+
+// match finds the first item of the group whose condition holds, -1 when none.
+func (t TransItem) match(number string) (int, error) {
+	for i, item := range t.Items {
+		ok, err := item.When.holds(number)
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// holds reports whether the number meets the condition, a nil condition always holds.
+// A number whose region is unknown never meets a province or city condition.
+func (c *TransCond) holds(number string) (bool, error) {
+	if c == nil {
+		return true, nil
+	}
+
+	if c.Match != "" {
+		r, err := compiled(c.re, c.Match)
+		if err != nil {
+			return false, err
+		}
+		if !r.MatchString(number) {
+			return false, nil
+		}
+	}
+
+	if c.Class != "" && region.Class(number) != c.Class {
+		return false, nil
+	}
+
+	if c.Province != "" || c.City != "" {
+		r, ok := region.Lookup(number)
+		if !ok {
+			return false, nil
+		}
+		in := (c.Province == "" || region.SameProvince(c.Province, r.Province)) &&
+			(c.City == "" || region.SameCity(c.City, r.City))
+		if in == c.Outside {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// compiled returns re when the rule is compiled, or compiles expr.
+func compiled(re *regexp.Regexp, expr string) (*regexp.Regexp, error) {
+	if re != nil {
+		return re, nil
+	}
+	return regexp.Compile(expr)
+}
@@ -0,0 +1,132 @@
+package call
+
+import (
+	"net/http"
+
+	"github.com/tcmzzz/lightcall/server/region"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// transType describes a TransItem type, for checking the rules and for the editor.
+type transType struct {
+	Type   string
+	Name   string
+	Params []transParam
+	Table  bool // uses TransItem.Table
+	Items  bool // uses TransItem.Items
+}
+
+// transParam is an item of TransItem.Param, the optional ones come last.
+type transParam struct {
+	Title    string
+	Optional bool
+	Pattern  string // regex the param must match, empty for any
+}
+
+var transTypes = []transType{
+	{Type: "prefix", Name: "增加前缀", Params: []transParam{{Title: "前缀"}}},
+	{Type: "suffix", Name: "增加后缀", Params: []transParam{{Title: "后缀"}}},
+	{Type: "replace", Name: "替换", Params: []transParam{{Title: "正则"}, {Title: "替换内容"}}},
+	{Type: "strip", Name: "去掉前缀", Params: []transParam{{Title: "前缀(多个用逗号分隔)"}}},
+	{Type: "lookup", Name: "查表替换前缀", Table: true},
+	{Type: "truncate", Name: "截取", Params: []transParam{
+		{Title: "位数", Pattern: `^[1-9][0-9]*$`},
+		{Title: "保留(head/tail)", Optional: true, Pattern: `^(head|tail)?$`},
+	}},
+	{Type: "pad", Name: "左侧补齐", Params: []transParam{
+		{Title: "位数", Pattern: `^[1-9][0-9]*$`},
+		{Title: "填充字符", Optional: true, Pattern: `^.?$`},
+	}},
+	{Type: "group", Name: "分组(第一条条件成立的规则生效)", Items: true},
+}
+
+func findTransType(typ string) (transType, bool) {
+	for _, t := range transTypes {
+		if t.Type == typ {
+			return t, true
+		}
+	}
+	return transType{}, false
+}
+
+// TransSchema is the JSON schema of the transcaller/transcallee rules.
+func TransSchema() map[string]any {
+
+	rules := make([]any, 0, len(transTypes))
+	for _, t := range transTypes {
+		props := map[string]any{
+			"type":  map[string]any{"const": t.Type},
+			"param": map[string]any{"type": []string{"array", "null"}, "maxItems": 0},
+			"when":  map[string]any{"$ref": "#/definitions/when"},
+		}
+		required := []string{"type"}
+
+		if len(t.Params) > 0 {
+			items := make([]any, 0, len(t.Params))
+			minItems := 0
+			for _, p := range t.Params {
+				item := map[string]any{"type": "string", "title": p.Title}
+				if p.Pattern != "" {
+					item["pattern"] = p.Pattern
+				}
+				items = append(items, item)
+				if !p.Optional {
+					minItems++
+				}
+			}
+			props["param"] = map[string]any{"type": "array", "items": items, "minItems": minItems}
+			required = append(required, "param")
+		}
+		if t.Table {
+			props["table"] = map[string]any{
+				"type":                 "object",
+				"title":                "原前缀 => 新前缀",
+				"additionalProperties": map[string]any{"type": "string"},
+				"minProperties":        1,
+			}
+			required = append(required, "table")
+		}
+		if t.Items {
+			props["items"] = map[string]any{"type": "array", "title": "规则", "items": map[string]any{"$ref": "#/definitions/rule"}}
+			required = append(required, "items")
+		}
+
+		rules = append(rules, map[string]any{
+			"title":                t.Name,
+			"type":                 "object",
+			"properties":           props,
+			"required":             required,
+			"additionalProperties": false,
+		})
+	}
+
+	when := map[string]any{
+		"title": "执行条件",
+		"type":  "object",
+		"properties": map[string]any{
+			"match":    map[string]any{"type": "string", "title": "匹配正则", "format": "regex"},
+			"class":    map[string]any{"type": "string", "title": "号码类型", "enum": []string{region.ClassMobile, region.ClassLandline, region.ClassShort}},
+			"province": map[string]any{"type": "string", "title": "归属省份"},
+			"city":     map[string]any{"type": "string", "title": "归属城市"},
+			"outside":  map[string]any{"type": "boolean", "title": "归属地不匹配时成立"},
+		},
+		"additionalProperties": false,
+	}
+
+	return map[string]any{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"title":   "号码变换规则",
+		"type":    "array",
+		"items":   map[string]any{"$ref": "#/definitions/rule"},
+		"definitions": map[string]any{
+			"rule": map[string]any{"oneOf": rules},
+			"when": when,
+		},
+	}
+}
+
+// HandleTransSchema returns the JSON schema of the transform rules for the editor.
+func HandleTransSchema(e *core.RequestEvent) error {
+	return e.JSON(http.StatusOK, TransSchema())
+}
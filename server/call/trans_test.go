@@ -1,8 +1,15 @@
 package call
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
 	"testing"
 
+	"github.com/tcmzzz/lightcall/server/region"
+
+	"github.com/pocketbase/pocketbase/core"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,14 +21,14 @@ func TestTrans(t *testing.T) {
 		expected string
 		hasErr   bool
 	}{
-		{"aaa", TransItem{"prefix", []string{"1#"}}, "1#aaa", false},
-		{"aaa", TransItem{"suffix", []string{"#1"}}, "aaa#1", false},
-		{"010-333", TransItem{"replace", []string{`(\w+)-`, "$1"}}, "010333", false},
-		{"020-333", TransItem{"replace", []string{`(\w+)-`, "$1"}}, "020333", false},
-		{"0113-333", TransItem{"replace", []string{`(\w+)-`, "$1"}}, "0113333", false},
-		{"0131-333", TransItem{"replace", []string{`(\w+)-`, "$1"}}, "0131333", false},
-		{"0131-333", TransItem{"replace", []string{`(\w+)-`, ""}}, "333", false},
-		{"0131-333", TransItem{"replace", []string{`(\w+-`, ""}}, "0131-333", true},
+		{"aaa", TransItem{Type: "prefix", Param: []string{"1#"}}, "1#aaa", false},
+		{"aaa", TransItem{Type: "suffix", Param: []string{"#1"}}, "aaa#1", false},
+		{"010-333", TransItem{Type: "replace", Param: []string{`(\w+)-`, "$1"}}, "010333", false},
+		{"020-333", TransItem{Type: "replace", Param: []string{`(\w+)-`, "$1"}}, "020333", false},
+		{"0113-333", TransItem{Type: "replace", Param: []string{`(\w+)-`, "$1"}}, "0113333", false},
+		{"0131-333", TransItem{Type: "replace", Param: []string{`(\w+)-`, "$1"}}, "0131333", false},
+		{"0131-333", TransItem{Type: "replace", Param: []string{`(\w+)-`, ""}}, "333", false},
+		{"0131-333", TransItem{Type: "replace", Param: []string{`(\w+-`, ""}}, "0131-333", true},
 	}
 
 	for _, c := range cs {
@@ -34,3 +41,245 @@ func TestTrans(t *testing.T) {
 		assert.Equal(t, c.expected, out)
 	}
 }
+
+func TestTransWhen(t *testing.T) {
+
+	file := path.Join(t.TempDir(), "mobile.csv")
+	assert.Nil(t, os.WriteFile(file, []byte("1380013,北京,北京\n1390020,广东,广州\n"), 0o644))
+	assert.Nil(t, region.LoadMobileFile(file))
+
+	// a Beijing gateway: 0 before out-of-area mobiles, area code off local landlines
+	trans := []TransItem{
+		{Type: "replace", Param: []string{`\D`, ""}},
+		{Type: "group", Items: []TransItem{
+			{Type: "prefix", Param: []string{"0"}, When: &TransCond{Class: "mobile", City: "北京市", Outside: true}},
+			{Type: "replace", Param: []string{`^010`, ""}, When: &TransCond{Class: "landline", City: "北京"}},
+			{Type: "prefix", Param: []string{"9"}, When: &TransCond{Match: `^0`}},
+		}},
+		{Type: "suffix", Param: []string{"#"}, When: &TransCond{Class: "short"}},
+	}
+
+	cs := []struct {
+		raw      string
+		expected string
+	}{
+		{"138-0013-8000", "13800138000"},     // local mobile
+		{"13900201111", "013900201111"},      // out-of-area mobile
+		{"13700001111", "13700001111"},       // unknown region
+		{"010-12345678", "12345678"},         // local landline
+		{"020-12345678", "902012345678"},     // out-of-area landline, falls to the last item
+		{"10086", "10086#"},                  // short
+		{"(0755)1234-5678", "9075512345678"}, // out-of-area landline
+	}
+
+	for _, c := range cs {
+		out, err := ApplyTrans(c.raw, trans)
+		assert.Nil(t, err, c.raw)
+		assert.Equal(t, c.expected, out, c.raw)
+	}
+
+	// conditions on a single step, province only
+	gd := TransItem{Type: "prefix", Param: []string{"gd"}, When: &TransCond{Province: "广东省"}}
+	out, err := gd.applyTrans("020-12345678")
+	assert.Nil(t, err)
+	assert.Equal(t, "gd020-12345678", out)
+	out, _ = gd.applyTrans("010-12345678")
+	assert.Equal(t, "010-12345678", out)
+
+	// invalid condition regex skips the step
+	bad := TransItem{Type: "prefix", Param: []string{"x"}, When: &TransCond{Match: `(`}}
+	out, err = bad.applyTrans("123")
+	assert.Error(t, err)
+	assert.Equal(t, "123", out)
+}
+
+func TestTransCompatible(t *testing.T) {
+
+	// rules saved before conditions existed
+	trans := make([]TransItem, 0)
+	assert.Nil(t, json.Unmarshal([]byte(`[{"type":"prefix","param":["1#"]},{"type":"replace","param":["-",""]}]`), &trans))
+
+	out, err := ApplyTrans("010-333", trans)
+	assert.Nil(t, err)
+	assert.Equal(t, "1#010333", out)
+
+	b, _ := json.Marshal(trans)
+	assert.Equal(t, `[{"type":"prefix","param":["1#"]},{"type":"replace","param":["-",""]}]`, string(b))
+}
+
+func TestParseTrans(t *testing.T) {
+
+	trans, err := ParseTrans("")
+	assert.Nil(t, err)
+	assert.Empty(t, trans)
+
+	trans, err = ParseTrans(`[{"type":"replace","param":["-",""],"when":{"match":"^0"}},{"type":"group","items":[{"type":"prefix","param":["0"]}]}]`)
+	assert.Nil(t, err)
+	assert.NotNil(t, trans[0].re)
+	assert.NotNil(t, trans[0].When.re)
+
+	cs := []struct {
+		raw string
+		err string
+	}{
+		{`{"type":"prefix"}`, "invalid rules"},
+		{`[{"type":"prefix","param":["0"],"wehn":{}}]`, "unknown field"},
+		{`[{"type":"prefix","param":[]}]`, "rule 1: prefix needs 1 param"},
+		{`[{"type":"prefix","param":["0"]},{"type":"replace","param":["(",""]}]`, "rule 2: invalid regex"},
+		{`[{"type":"upper","param":[]}]`, `rule 1: unknown type "upper"`},
+		{`[{"type":"prefix","param":["0"],"when":{"class":"fax"}}]`, "unknown condition class"},
+		{`[{"type":"group","items":[{"type":"suffix","param":["#"],"when":{"match":"("}}]}]`, "rule 1: group: rule 1: invalid condition regex"},
+	}
+
+	for _, c := range cs {
+		_, err := ParseTrans(c.raw)
+		if assert.Error(t, err, c.raw) {
+			assert.Contains(t, err.Error(), c.err, c.raw)
+		}
+	}
+}
+
+func TestTraceTrans(t *testing.T) {
+
+	trans, err := ParseTrans(`[
+		{"type":"replace","param":["-",""]},
+		{"type":"prefix","param":["9"],"when":{"match":"^1"}},
+		{"type":"group","items":[
+			{"type":"suffix","param":["#"],"when":{"class":"short"}},
+			{"type":"prefix","param":["0"],"when":{"class":"landline"}}
+		]}
+	]`)
+	assert.Nil(t, err)
+
+	steps := TraceTrans("010-12345678", trans)
+	assert.Len(t, steps, 3)
+	assert.Equal(t, TransStep{Rule: 0, Type: "replace", Input: "010-12345678", Output: "01012345678", Applied: true}, steps[0])
+	assert.False(t, steps[1].Applied)
+	assert.Equal(t, "01012345678", steps[1].Output)
+	assert.True(t, steps[2].Applied)
+	assert.Equal(t, 1, *steps[2].Item)
+	assert.Equal(t, "001012345678", steps[2].Output)
+
+	out, err := ApplyTrans("010-12345678", trans)
+	assert.Nil(t, err)
+	assert.Equal(t, steps[2].Output, out)
+
+	// group without matching item
+	steps = TraceTrans("13800138000", trans)
+	assert.Nil(t, steps[2].Item)
+	assert.Equal(t, "913800138000", steps[2].Output)
+
+	// rules not compiled still show the error of the step
+	steps = TraceTrans("123", []TransItem{{Type: "replace", Param: []string{"(", ""}}, {Type: "suffix", Param: []string{"#"}}})
+	assert.NotEmpty(t, steps[0].Error)
+	assert.Equal(t, "123#", steps[1].Output)
+}
+
+func TestTransOf(t *testing.T) {
+
+	gw := core.NewRecord(core.NewBaseCollection("outgw"))
+	gw.Id = "gw_trans_of"
+	gw.Set("updated", "2025-01-01 00:00:00.000Z")
+	gw.Set("transcaller", `[{"type":"prefix","param":["1#"]}]`)
+
+	caller, callee := transOf(nil, gw)
+	assert.Len(t, caller, 1)
+	assert.Empty(t, callee)
+
+	// not compiled again until updated
+	gw.Set("transcaller", `[{"type":"prefix","param":["2#"]}]`)
+	caller, _ = transOf(nil, gw)
+	assert.Equal(t, "1#", caller[0].Param[0])
+
+	gw.Set("updated", "2025-01-01 00:00:01.000Z")
+	caller, _ = transOf(nil, gw)
+	assert.Equal(t, "2#", caller[0].Param[0])
+}
+
+func TestTransOps(t *testing.T) {
+
+	cs := []struct {
+		raw      string
+		t        TransItem
+		expected string
+	}{
+		{"+8613800138000", TransItem{Type: "strip", Param: []string{"+86, 0086,86"}}, "13800138000"},
+		{"008613800138000", TransItem{Type: "strip", Param: []string{"+86,0086,86"}}, "13800138000"},
+		{"13800138000", TransItem{Type: "strip", Param: []string{"+86,0086"}}, "13800138000"},
+		{"01012345678", TransItem{Type: "lookup", Table: map[string]string{"010": "9010", "0": "8", "020": ""}}, "901012345678"},
+		{"02012345678", TransItem{Type: "lookup", Table: map[string]string{"010": "9010", "0": "8", "020": ""}}, "12345678"},
+		{"07551234567", TransItem{Type: "lookup", Table: map[string]string{"010": "9010", "0": "8", "020": ""}}, "87551234567"},
+		{"13800138000", TransItem{Type: "lookup", Table: map[string]string{"010": "9010", "0": "8", "020": ""}}, "13800138000"},
+		{"8613800138000", TransItem{Type: "truncate", Param: []string{"11", "tail"}}, "13800138000"},
+		{"13800138000", TransItem{Type: "truncate", Param: []string{"3"}}, "138"},
+		{"13800138000", TransItem{Type: "truncate", Param: []string{"3", "head"}}, "138"},
+		{"138", TransItem{Type: "truncate", Param: []string{"11", "tail"}}, "138"},
+		{"123", TransItem{Type: "pad", Param: []string{"6"}}, "000123"},
+		{"123", TransItem{Type: "pad", Param: []string{"5", "*"}}, "**123"},
+		{"1234567", TransItem{Type: "pad", Param: []string{"5"}}, "1234567"},
+	}
+
+	for _, c := range cs {
+		out, err := c.t.applyTrans(c.raw)
+		assert.Nil(t, err, c.raw)
+		assert.Equal(t, c.expected, out, c.raw)
+
+		// compiled rules work the same
+		trans, err := CompileTrans([]TransItem{c.t})
+		assert.Nil(t, err, c.raw)
+		out, _ = ApplyTrans(c.raw, trans)
+		assert.Equal(t, c.expected, out, c.raw)
+	}
+
+	invalid := []struct {
+		raw string
+		err string
+	}{
+		{`[{"type":"strip","param":[]}]`, "strip needs 1 param"},
+		{`[{"type":"lookup","table":{}}]`, "lookup needs a table"},
+		{`[{"type":"truncate","param":["0"]}]`, "truncate param 位数 invalid"},
+		{`[{"type":"truncate","param":["8","middle"]}]`, "truncate param 保留(head/tail) invalid"},
+		{`[{"type":"pad","param":["x"]}]`, "pad param 位数 invalid"},
+		{`[{"type":"pad","param":["8","00"]}]`, "pad param 填充字符 invalid"},
+		{`[{"type":"replace","param":["a"]}]`, "replace needs 2 params"},
+	}
+	for _, c := range invalid {
+		_, err := ParseTrans(c.raw)
+		if assert.Error(t, err, c.raw) {
+			assert.Contains(t, err.Error(), c.err, c.raw)
+		}
+	}
+}
+
+func TestTransSchema(t *testing.T) {
+
+	b, err := json.Marshal(TransSchema())
+	assert.Nil(t, err)
+
+	schema := struct {
+		Definitions struct {
+			Rule struct {
+				OneOf []struct {
+					Properties map[string]json.RawMessage `json:"properties"`
+				} `json:"oneOf"`
+			} `json:"rule"`
+		} `json:"definitions"`
+	}{}
+	assert.Nil(t, json.Unmarshal(b, &schema))
+
+	// every type is described, and every type checks its params
+	types := make([]string, 0)
+	for _, r := range schema.Definitions.Rule.OneOf {
+		typ := struct {
+			Const string `json:"const"`
+		}{}
+		assert.Nil(t, json.Unmarshal(r.Properties["type"], &typ))
+		types = append(types, typ.Const)
+	}
+	assert.Equal(t, []string{"prefix", "suffix", "replace", "strip", "lookup", "truncate", "pad", "group"}, types)
+
+	for _, typ := range types {
+		_, err := (TransItem{Type: typ}).compile()
+		assert.NotContains(t, fmt.Sprint(err), "unknown type", typ)
+	}
+}
@@ -0,0 +1,56 @@
+// Package callstate is the state of a call activity, moved forward by whichever of
+// the dial path, the ESL events and the cdr import tells first.
+package callstate
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// states of activity.state
+const (
+	Created   = "created"   // activity created, not dialed yet
+	Dialing   = "dialing"   // FreeSWITCH got the dialplan
+	Ringing   = "ringing"   // the callee is ringing
+	Answered  = "answered"  // the callee answered
+	Ended     = "ended"     // the call answered ended
+	Failed    = "failed"    // not placed, or ended without answer
+	Abandoned = "abandoned" // never dialed, or never reported by FreeSWITCH
+)
+
+// rank orders the states, the final ones share the highest rank.
+var rank = map[string]int{
+	"":        0,
+	Created:   1,
+	Dialing:   2,
+	Ringing:   3,
+	Answered:  4,
+	Ended:     5,
+	Failed:    5,
+	Abandoned: 5,
+}
+
+// Final tells whether the call is over in the state.
+func Final(state string) bool { return rank[state] == rank[Ended] }
+
+// CanTransit tells whether the state may go from one to the other. States only go forward
+// and a final state is kept, except an abandoned call whose cdr arrives late.
+func CanTransit(from, to string) bool {
+	r, ok := rank[to]
+	if !ok || to == "" {
+		return false
+	}
+	if from == Abandoned && (to == Ended || to == Failed) {
+		return true
+	}
+	return r > rank[from]
+}
+
+// Transit sets the state of the activity when it may, returning whether it did.
+// The activity is not saved.
+func Transit(activity *core.Record, to string) bool {
+	if !CanTransit(activity.GetString("state"), to) {
+		return false
+	}
+	activity.Set("state", to)
+	return true
+}
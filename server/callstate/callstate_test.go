@@ -0,0 +1,38 @@
+package callstate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanTransit(t *testing.T) {
+
+	// forward, skipping is fine as events may be missed
+	assert.True(t, CanTransit("", Created))
+	assert.True(t, CanTransit(Created, Dialing))
+	assert.True(t, CanTransit(Dialing, Ringing))
+	assert.True(t, CanTransit(Ringing, Answered))
+	assert.True(t, CanTransit(Answered, Ended))
+	assert.True(t, CanTransit(Created, Failed))
+	assert.True(t, CanTransit(Dialing, Answered))
+	assert.True(t, CanTransit(Created, Abandoned))
+
+	// never back
+	assert.False(t, CanTransit(Answered, Ringing))
+	assert.False(t, CanTransit(Dialing, Created))
+	assert.False(t, CanTransit(Ringing, Ringing))
+
+	// final states are kept, except a late cdr of an abandoned call
+	assert.False(t, CanTransit(Ended, Failed))
+	assert.False(t, CanTransit(Failed, Answered))
+	assert.False(t, CanTransit(Ended, Abandoned))
+	assert.True(t, CanTransit(Abandoned, Ended))
+	assert.True(t, CanTransit(Abandoned, Failed))
+
+	assert.False(t, CanTransit(Created, "unknown"))
+	assert.False(t, CanTransit(Created, ""))
+
+	assert.True(t, Final(Abandoned))
+	assert.False(t, Final(Answered))
+}
@@ -42,6 +42,7 @@ type Response struct {
 type Result struct {
 	Pass bool   `json:"pass"`
 	Msg  string `json:"msg"`
+	Code string `json:"code,omitempty"` // 被拦截时的失败码
 }
 
 type parseFunc func([]byte) (*Result, error)
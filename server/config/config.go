@@ -14,14 +14,51 @@ type Provider interface {
 	Privacy() (*Privacy, error)
 	Cloud() (*Cloud, error)
 	IceServers() ([]IceServer, error)
+	CallingHours() (*CallingHours, error)
+	Phone() (*Phone, error)
+	Recording() (*Recording, error)
+	FreeSwitch() (*FreeSwitch, error)
 	ClearCache()
 }
 
 // 拨号配置 (name="dial")
 type Dial struct {
 	Caller struct {
-		Affinity bool `json:"affinity"` // 主叫亲和性配置
+		Affinity bool           `json:"affinity"` // 主叫亲和性配置
+		Strategy string         `json:"strategy"` // 选号策略: random/roundrobin/lru/connectrate/affinity, 默认 random
+		Region   bool           `json:"region"`   // 优先使用与被叫同城/同省的号码
+		Params   StrategyParams `json:"params"`   // 选号策略参数
+		Mark     MarkFilter     `json:"mark"`     // 按号码标记排除或降权
 	} `json:"caller"`
+	Failover Failover `json:"failover"` // 网关切换
+	Abandon  int      `json:"abandon"`  // 超过该分钟数仍未收到 CDR 的通话标记为放弃, 默认 30, 小于 0 时不处理
+}
+
+// 网关切换配置, 呼叫失败时依次改用其他网关的号码重拨
+type Failover struct {
+	Attempts int      `json:"attempts"` // 最多尝试次数(含首次), 小于等于 1 时不切换
+	Causes   []string `json:"causes"`   // 触发切换的 SIP 响应码或 FreeSWITCH 挂断原因, 如 ["503", "403"]
+}
+
+// 号码标记过滤, 标记来自 number.mark
+type MarkFilter struct {
+	Exclude  []string      `json:"exclude"`  // 有这些等级标记的号码不参与选号, 如 ["danger"]
+	Penalize []MarkPenalty `json:"penalize"` // 降权规则, 命中多条时权重相乘
+}
+
+// 号码标记降权规则
+type MarkPenalty struct {
+	Severity string  `json:"severity"` // 标记等级, 如 warning
+	Over     int     `json:"over"`     // 该等级标记次数合计超过此值时降权
+	Weight   float64 `json:"weight"`   // 降权系数, 0~1
+}
+
+// 选号策略参数
+type StrategyParams struct {
+	Fallback     string  `json:"fallback"`     // affinity 未命中时使用的策略, 默认 random
+	AffinityDays int     `json:"affinityDays"` // affinity 回溯天数, 0 为不限制
+	Window       int     `json:"window"`       // connectrate 统计窗口(小时), 默认 24
+	Explore      float64 `json:"explore"`      // connectrate 最低权重, 保证新号码也有流量, 默认 0.05
 }
 
 // 隐私配置 (name="privacy")
@@ -49,6 +86,54 @@ type IceServer struct {
 	Credential string `json:"credential,omitempty"`
 }
 
+// 呼叫时段配置 (name="calling_hours"), 不在允许时段内的呼叫会被拒绝
+type CallingHours struct {
+	Enable     bool                 `json:"enable"`     // 是否限制呼叫时段
+	Timezone   string               `json:"timezone"`   // 时区, 如 Asia/Shanghai, 默认服务器时区
+	Windows    []HoursWindow        `json:"windows"`    // 允许呼叫的时段, 为空时不限制时段
+	Holidays   []string             `json:"holidays"`   // 不允许呼叫的日期, 如 2025-10-01
+	Objectives map[string]HoursRule `json:"objectives"` // 按目标 id 覆盖
+}
+
+// 目标的呼叫时段, windows 不为空时替换默认时段, holidays 追加到默认日期
+type HoursRule struct {
+	Windows  []HoursWindow `json:"windows"`
+	Holidays []string      `json:"holidays"`
+}
+
+// 呼叫时段
+type HoursWindow struct {
+	Weekdays []int  `json:"weekdays"` // 1-7 为周一到周日, 为空时每天
+	Start    string `json:"start"`    // 开始时间, 如 09:00
+	End      string `json:"end"`      // 结束时间(不含), 如 20:00, 最晚 24:00
+}
+
+// 号码配置 (name="phone")
+type Phone struct {
+	DefaultCountry string `json:"defaultCountry"` // 没有国际区号的号码所属国家, 如 CN, 默认 CN
+}
+
+// 录音配置 (name="recording")
+type Recording struct {
+	Enable     bool                     `json:"enable"`     // 是否录音
+	Format     string                   `json:"format"`     // 录音格式: mp3/wav, 默认 mp3
+	Stereo     bool                     `json:"stereo"`     // 是否双声道(主被叫各一个声道)
+	Filename   string                   `json:"filename"`   // 文件名模板, 须包含 {activity}, 可用 {date} {task} {user}, 如 {date}/{activity}
+	Objectives map[string]RecordingRule `json:"objectives"` // 按目标 id 覆盖是否录音
+}
+
+// 目标的录音配置, 如法务要求不能录音的客户
+type RecordingRule struct {
+	Enable bool `json:"enable"`
+}
+
+// FreeSWITCH 回调配置 (name="freeswitch"), 限制 xml_curl 请求 /api/custom/call/sip/fs
+type FreeSwitch struct {
+	Allow  []string `json:"allow"`  // 允许的来源 IP 或 CIDR, 如 ["192.168.66.13", "10.0.0.0/8"], 为空时不限制
+	Secret string   `json:"secret"` // 共享密钥, 不为空时请求须带 HMAC 签名头
+	Skew   int      `json:"skew"`   // 签名时间戳允许的偏差(秒), 默认 300
+}
+
 type instance struct {
 	app   core.App
 	cache *cache.Cache
@@ -133,3 +218,51 @@ func (i *instance) IceServers() ([]IceServer, error) {
 	}
 	return ret, nil
 }
+
+func (i *instance) CallingHours() (*CallingHours, error) {
+	str, err := i.getConfig("calling_hours")
+	if err != nil {
+		return nil, err
+	}
+	ret := &CallingHours{}
+	if err := json.Unmarshal([]byte(str), ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (i *instance) Phone() (*Phone, error) {
+	str, err := i.getConfig("phone")
+	if err != nil {
+		return nil, err
+	}
+	ret := &Phone{}
+	if err := json.Unmarshal([]byte(str), ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (i *instance) Recording() (*Recording, error) {
+	str, err := i.getConfig("recording")
+	if err != nil {
+		return nil, err
+	}
+	ret := &Recording{}
+	if err := json.Unmarshal([]byte(str), ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (i *instance) FreeSwitch() (*FreeSwitch, error) {
+	str, err := i.getConfig("freeswitch")
+	if err != nil {
+		return nil, err
+	}
+	ret := &FreeSwitch{}
+	if err := json.Unmarshal([]byte(str), ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,390 @@
+package esl
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNotConnected = errors.New("esl not connected")
+	ErrTimeout      = errors.New("esl reply timeout")
+)
+
+const (
+	dialTimeout  = 5 * time.Second
+	replyTimeout = 10 * time.Second
+	minBackoff   = time.Second
+	maxBackoff   = 30 * time.Second
+)
+
+// subscription is the events a handler subscribes, optionally filtered by a header.
+// A filter value like /regex/ is a regular expression, as in the ESL filter command.
+type subscription struct {
+	events []string
+	header string
+	value  string
+	re     *regexp.Regexp
+	handle func(Event)
+}
+
+func (s *subscription) match(e Event) bool {
+	found := false
+	for _, name := range s.events {
+		if name == e.Name() {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	if s.header == "" {
+		return true
+	}
+	v, ok := e[s.header]
+	if s.re != nil {
+		return ok && s.re.MatchString(v)
+	}
+	return ok && v == s.value
+}
+
+// Client keeps a connection to the event socket of FreeSWITCH, reconnecting when it is lost.
+// Subscriptions are sent again on every connection.
+type Client struct {
+	addr     string
+	password string
+	logger   *slog.Logger
+	timeout  time.Duration // reply timeout of the commands
+
+	mu      sync.Mutex
+	subs    []*subscription
+	conn    net.Conn
+	waiters []chan *message // waiting for the replies of the commands sent, in order
+
+	writeMu sync.Mutex
+
+	started  bool
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func New(addr, password string, logger *slog.Logger) *Client {
+	return &Client{
+		addr:     addr,
+		password: password,
+		logger:   logger,
+		timeout:  replyTimeout,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Subscribe calls handle with the events of the names, whose header matches value when header is set.
+// It should be called before Start. Events are handled in order on the reading goroutine,
+// so handle must not wait the replies of commands.
+func (c *Client) Subscribe(header, value string, handle func(Event), events ...string) error {
+	s := &subscription{events: events, header: header, value: value, handle: handle}
+	if len(value) > 1 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
+		re, err := regexp.Compile(value[1 : len(value)-1])
+		if err != nil {
+			return errors.Wrapf(err, "invalid filter %q", value)
+		}
+		s.re = re
+	}
+
+	c.mu.Lock()
+	c.subs = append(c.subs, s)
+	c.mu.Unlock()
+	return nil
+}
+
+// Start connects in the background until Stop.
+func (c *Client) Start() {
+	c.mu.Lock()
+	c.started = true
+	c.mu.Unlock()
+	go c.run()
+}
+
+// Stop closes the connection and waits the client to exit.
+func (c *Client) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		c.mu.Lock()
+		if c.conn != nil {
+			c.conn.Close()
+		}
+		c.mu.Unlock()
+	})
+
+	c.mu.Lock()
+	started := c.started
+	c.mu.Unlock()
+	if started {
+		<-c.done
+	}
+}
+
+// Connected tells whether the client is connected and authenticated.
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+func (c *Client) stopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+
+	backoff := minBackoff
+	for {
+		start := time.Now()
+		err := c.serve()
+		if c.stopped() {
+			return
+		}
+		// a connection lasted long enough was fine, reconnect soon
+		if time.Since(start) > maxBackoff {
+			backoff = minBackoff
+		}
+		c.logger.Warn("esl disconnected", "addr", c.addr, "err", err, "retry", backoff)
+
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// serve connects, authenticates and subscribes, then reads until the connection is lost.
+func (c *Client) serve() error {
+	conn, err := net.DialTimeout("tcp", c.addr, dialTimeout)
+	if err != nil {
+		return errors.Wrap(err, "dial")
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	if err := c.auth(conn, r); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.stopped() {
+		c.mu.Unlock()
+		return nil
+	}
+	c.conn = conn
+	subs := c.subs
+	c.mu.Unlock()
+
+	readErr := make(chan error, 1)
+	go func() { readErr <- c.read(r) }()
+
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		for _, w := range c.waiters {
+			close(w)
+		}
+		c.waiters = nil
+		c.mu.Unlock()
+	}()
+
+	if err := c.subscribe(subs); err != nil {
+		conn.Close()
+		<-readErr
+		return err
+	}
+	c.logger.Info("esl connected", "addr", c.addr)
+
+	return <-readErr
+}
+
+func (c *Client) auth(conn net.Conn, r *bufio.Reader) error {
+	conn.SetDeadline(time.Now().Add(c.timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	msg, err := readMessage(r)
+	if err != nil {
+		return errors.Wrap(err, "read auth request")
+	}
+	if msg.contentType() != "auth/request" {
+		return errors.Errorf("unexpected %q, want auth/request", msg.contentType())
+	}
+
+	if _, err := io.WriteString(conn, "auth "+c.password+"\n\n"); err != nil {
+		return errors.Wrap(err, "write auth")
+	}
+	msg, err = readMessage(r)
+	if err != nil {
+		return errors.Wrap(err, "read auth reply")
+	}
+	if err := replyError(msg.Header["Reply-Text"]); err != nil || msg.contentType() != "command/reply" {
+		return errors.Errorf("auth fail: %s", msg.Header["Reply-Text"])
+	}
+	return nil
+}
+
+// subscribe asks the events and filters of the subscriptions.
+func (c *Client) subscribe(subs []*subscription) error {
+	names, seen := []string{}, map[string]bool{}
+	for _, s := range subs {
+		for _, name := range s.events {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	cmds := []string{"event plain " + strings.Join(names, " ")}
+	for _, s := range subs {
+		if s.header != "" {
+			cmds = append(cmds, "filter "+s.header+" "+s.value)
+		}
+	}
+	for _, cmd := range cmds {
+		msg, err := c.send(cmd)
+		if err != nil {
+			return errors.Wrapf(err, "send %q", cmd)
+		}
+		if err := replyError(msg.Header["Reply-Text"]); err != nil {
+			return errors.Wrapf(err, "%q", cmd)
+		}
+	}
+	return nil
+}
+
+// read dispatches the replies to the waiters and the events to the subscriptions, in order.
+func (c *Client) read(r *bufio.Reader) error {
+	for {
+		msg, err := readMessage(r)
+		if err != nil {
+			return errors.Wrap(err, "read")
+		}
+
+		switch msg.contentType() {
+		case "command/reply", "api/response":
+			c.mu.Lock()
+			if len(c.waiters) > 0 {
+				w := c.waiters[0]
+				c.waiters = c.waiters[1:]
+				w <- msg
+			}
+			c.mu.Unlock()
+
+		case "text/event-plain":
+			e, err := parseEvent(msg.Body)
+			if err != nil {
+				c.logger.Warn("esl invalid event", "err", err)
+				continue
+			}
+			c.dispatch(e)
+
+		case "text/disconnect-notice":
+			return errors.New("disconnected by freeswitch")
+		}
+	}
+}
+
+func (c *Client) dispatch(e Event) {
+	c.mu.Lock()
+	subs := c.subs
+	c.mu.Unlock()
+
+	for _, s := range subs {
+		if s.match(e) {
+			s.handle(e)
+		}
+	}
+}
+
+// send writes the command and waits its reply. Replies come in the order of the commands,
+// a late reply would be taken for the one of the next command, so the connection is closed
+// on timeout to reconnect in sync.
+func (c *Client) send(cmd string) (*message, error) {
+	w := make(chan *message, 1)
+
+	c.writeMu.Lock()
+	c.mu.Lock()
+	conn := c.conn
+	if conn == nil {
+		c.mu.Unlock()
+		c.writeMu.Unlock()
+		return nil, ErrNotConnected
+	}
+	c.waiters = append(c.waiters, w)
+	c.mu.Unlock()
+
+	_, err := io.WriteString(conn, cmd+"\n\n")
+	c.writeMu.Unlock()
+	if err != nil {
+		return nil, errors.Wrap(err, "write")
+	}
+
+	select {
+	case msg, ok := <-w:
+		if !ok {
+			return nil, ErrNotConnected
+		}
+		return msg, nil
+	case <-time.After(c.timeout):
+		c.logger.Warn("esl reply timeout, reconnect", "cmd", cmd)
+		conn.Close()
+		return nil, ErrTimeout
+	}
+}
+
+// Api runs the api command, such as "uuid_kill <uuid>", returning its response.
+// A response of -ERR is an error.
+func (c *Client) Api(cmd string) (string, error) {
+	msg, err := c.send("api " + cmd)
+	if err != nil {
+		return "", err
+	}
+	resp := strings.TrimSpace(string(msg.Body))
+	if err := replyError(resp); err != nil {
+		return "", errors.Wrapf(err, "api %s", cmd)
+	}
+	return resp, nil
+}
+
+// BgApi runs the api command in the background, returning the Job-UUID of it.
+// The result comes with the BACKGROUND_JOB event.
+func (c *Client) BgApi(cmd string) (string, error) {
+	msg, err := c.send("bgapi " + cmd)
+	if err != nil {
+		return "", err
+	}
+	text := msg.Header["Reply-Text"]
+	if err := replyError(text); err != nil {
+		return "", errors.Wrapf(err, "bgapi %s", cmd)
+	}
+	if job := msg.Header["Job-UUID"]; job != "" {
+		return job, nil
+	}
+	return strings.TrimSpace(strings.TrimPrefix(text, "+OK Job-UUID:")), nil
+}
@@ -0,0 +1,286 @@
+package esl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeServer is an event socket of FreeSWITCH accepting one connection at a time.
+type fakeServer struct {
+	ln       net.Listener
+	password string
+	cmds     chan string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newFakeServer(t *testing.T, password string) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{ln: ln, password: password, cmds: make(chan string, 100)}
+	go s.accept()
+	t.Cleanup(func() {
+		ln.Close()
+		s.drop()
+	})
+	return s
+}
+
+func (s *fakeServer) addr() string { return s.ln.Addr().String() }
+
+func (s *fakeServer) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conn = conn
+		s.mu.Unlock()
+		go s.serve(conn)
+	}
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	s.write(conn, "Content-Type: auth/request\n\n")
+
+	r := bufio.NewReader(conn)
+	for {
+		cmd, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.cmds <- cmd
+
+		switch {
+		case strings.HasPrefix(cmd, "auth "):
+			if strings.TrimPrefix(cmd, "auth ") != s.password {
+				s.write(conn, "Content-Type: command/reply\nReply-Text: -ERR invalid\n\n")
+				conn.Close()
+				return
+			}
+			s.write(conn, "Content-Type: command/reply\nReply-Text: +OK accepted\n\n")
+		case strings.HasPrefix(cmd, "api "):
+			body := "+OK " + strings.TrimPrefix(cmd, "api ") + "\n"
+			if strings.Contains(cmd, "gone") {
+				body = "-ERR No such channel!\n"
+			}
+			if strings.Contains(cmd, "slow") {
+				time.Sleep(300 * time.Millisecond)
+			}
+			s.write(conn, fmt.Sprintf("Content-Type: api/response\nContent-Length: %d\n\n%s", len(body), body))
+		case strings.HasPrefix(cmd, "bgapi "):
+			s.write(conn, "Content-Type: command/reply\nReply-Text: +OK Job-UUID: job-1\nJob-UUID: job-1\n\n")
+		default:
+			s.write(conn, "Content-Type: command/reply\nReply-Text: +OK\n\n")
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) (string, error) {
+	lines := []string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if len(lines) == 0 {
+				continue
+			}
+			return strings.Join(lines, "\n"), nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+func (s *fakeServer) write(conn net.Conn, str string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	io.WriteString(conn, str)
+}
+
+// emit sends an event to the client connected.
+func (s *fakeServer) emit(e Event) {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	body := ""
+	for _, k := range keys {
+		body += k + ": " + url.PathEscape(e[k]) + "\n"
+	}
+
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	s.write(conn, fmt.Sprintf("Content-Length: %d\nContent-Type: text/event-plain\n\n%s", len(body), body))
+}
+
+func (s *fakeServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// waitCmds waits n commands the server received.
+func (s *fakeServer) waitCmds(t *testing.T, n int) []string {
+	cmds := []string{}
+	for len(cmds) < n {
+		select {
+		case cmd := <-s.cmds:
+			cmds = append(cmds, cmd)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("want %d commands, got %v", n, cmds)
+		}
+	}
+	return cmds
+}
+
+func waitEvent(t *testing.T, events chan Event) Event {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+		return nil
+	}
+}
+
+func TestClientSubscribe(t *testing.T) {
+
+	srv := newFakeServer(t, "ClueCon")
+	events := make(chan Event, 10)
+
+	c := New(srv.addr(), "ClueCon", slog.Default())
+	assert.Nil(t, c.Subscribe("variable_activityId", "/^.+$/", func(e Event) { events <- e }, "CHANNEL_ANSWER", "CHANNEL_HANGUP"))
+	assert.Error(t, c.Subscribe("variable_x", "/(/", func(e Event) {}, "CHANNEL_ANSWER"))
+	c.Start()
+	defer c.Stop()
+
+	assert.Equal(t, []string{
+		"auth ClueCon",
+		"event plain CHANNEL_ANSWER CHANNEL_HANGUP",
+		"filter variable_activityId /^.+$/",
+	}, srv.waitCmds(t, 3))
+	assert.Eventually(t, c.Connected, time.Second, 10*time.Millisecond)
+
+	// not subscribed, not of an activity, then the one wanted
+	srv.emit(Event{"Event-Name": "CHANNEL_PROGRESS", "variable_activityId": "a1"})
+	srv.emit(Event{"Event-Name": "CHANNEL_ANSWER", "Unique-ID": "u0"})
+	srv.emit(Event{"Event-Name": "CHANNEL_ANSWER", "Unique-ID": "u1", "variable_activityId": "a1", "Caller-Caller-ID-Name": "小 李"})
+
+	e := waitEvent(t, events)
+	assert.Equal(t, "CHANNEL_ANSWER", e.Name())
+	assert.Equal(t, "u1", e.UUID())
+	assert.Equal(t, "a1", e.Var("activityId"))
+	assert.Equal(t, "小 李", e["Caller-Caller-ID-Name"])
+	assert.Len(t, events, 0)
+}
+
+func TestClientReconnect(t *testing.T) {
+
+	srv := newFakeServer(t, "ClueCon")
+	events := make(chan Event, 10)
+
+	c := New(srv.addr(), "ClueCon", slog.Default())
+	c.Subscribe("", "", func(e Event) { events <- e }, "CHANNEL_HANGUP")
+	c.Start()
+	defer c.Stop()
+
+	srv.waitCmds(t, 2)
+	srv.drop()
+
+	// subscribed again on the new connection
+	assert.Equal(t, []string{"auth ClueCon", "event plain CHANNEL_HANGUP"}, srv.waitCmds(t, 2))
+	assert.Eventually(t, c.Connected, time.Second, 10*time.Millisecond)
+
+	srv.emit(Event{"Event-Name": "CHANNEL_HANGUP", "Unique-ID": "u2"})
+	assert.Equal(t, "u2", waitEvent(t, events).UUID())
+}
+
+func TestClientAuthFail(t *testing.T) {
+
+	srv := newFakeServer(t, "ClueCon")
+
+	c := New(srv.addr(), "wrong", slog.Default())
+	c.Start()
+
+	assert.Equal(t, []string{"auth wrong"}, srv.waitCmds(t, 1))
+	assert.False(t, c.Connected())
+	c.Stop()
+}
+
+func TestClientStopNotStarted(t *testing.T) {
+
+	c := New("127.0.0.1:1", "ClueCon", slog.Default())
+	c.Stop()
+	assert.False(t, c.Connected())
+}
+
+func TestClientApi(t *testing.T) {
+
+	srv := newFakeServer(t, "ClueCon")
+
+	c := New(srv.addr(), "ClueCon", slog.Default())
+	_, err := c.Api("uuid_kill u1")
+	assert.ErrorIs(t, err, ErrNotConnected)
+
+	c.Start()
+	defer c.Stop()
+	srv.waitCmds(t, 1)
+	assert.Eventually(t, c.Connected, time.Second, 10*time.Millisecond)
+
+	resp, err := c.Api("uuid_kill u1")
+	assert.Nil(t, err)
+	assert.Equal(t, "+OK uuid_kill u1", resp)
+
+	_, err = c.Api("uuid_hold gone")
+	assert.EqualError(t, err, "api uuid_hold gone: No such channel!")
+
+	job, err := c.BgApi("originate user/u1 &eavesdrop(a)")
+	assert.Nil(t, err)
+	assert.Equal(t, "job-1", job)
+}
+
+func TestClientReplyTimeout(t *testing.T) {
+
+	srv := newFakeServer(t, "ClueCon")
+
+	c := New(srv.addr(), "ClueCon", slog.Default())
+	c.timeout = 100 * time.Millisecond
+	c.Start()
+	defer c.Stop()
+	srv.waitCmds(t, 1)
+	assert.Eventually(t, c.Connected, time.Second, 10*time.Millisecond)
+
+	_, err := c.Api("uuid_hold slow")
+	assert.ErrorIs(t, err, ErrTimeout)
+
+	// reconnected, the late reply is not taken for the next command's
+	assert.Equal(t, []string{"api uuid_hold slow", "auth ClueCon"}, srv.waitCmds(t, 2))
+	assert.Eventually(t, c.Connected, time.Second, 10*time.Millisecond)
+
+	resp, err := c.Api("uuid_kill u1")
+	assert.Nil(t, err)
+	assert.Equal(t, "+OK uuid_kill u1", resp)
+}
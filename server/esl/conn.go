@@ -0,0 +1,99 @@
+package esl
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// message is a message of the event socket: headers, then a body of Content-Length.
+type message struct {
+	Header map[string]string
+	Body   []byte
+}
+
+func (m *message) contentType() string { return m.Header["Content-Type"] }
+
+// Event is an event of FreeSWITCH, its headers url-decoded.
+type Event map[string]string
+
+// Name is the Event-Name, such as CHANNEL_ANSWER.
+func (e Event) Name() string { return e["Event-Name"] }
+
+// UUID is the uuid of the channel of the event.
+func (e Event) UUID() string { return e["Unique-ID"] }
+
+// Var is a channel variable of the event.
+func (e Event) Var(name string) string { return e["variable_"+name] }
+
+// readHeader reads "Key: Value" lines until an empty line.
+func readHeader(r *bufio.Reader, decode bool) (map[string]string, error) {
+	header := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if len(header) == 0 {
+				continue // blank lines between messages
+			}
+			return header, nil
+		}
+
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, errors.Errorf("invalid header line %q", line)
+		}
+		v = strings.TrimSpace(v)
+		if decode {
+			if dv, err := url.PathUnescape(v); err == nil {
+				v = dv
+			}
+		}
+		header[k] = v
+	}
+}
+
+func readMessage(r *bufio.Reader) (*message, error) {
+	header, err := readHeader(r, false)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &message{Header: header}
+	if cl := header["Content-Length"]; cl != "" {
+		n, err := strconv.Atoi(cl)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid Content-Length %q", cl)
+		}
+		msg.Body = make([]byte, n)
+		if _, err := io.ReadFull(r, msg.Body); err != nil {
+			return nil, errors.Wrap(err, "read body")
+		}
+	}
+	return msg, nil
+}
+
+// parseEvent parses the body of a text/event-plain message.
+func parseEvent(body []byte) (Event, error) {
+	r := bufio.NewReader(strings.NewReader(string(body) + "\n\n"))
+	header, err := readHeader(r, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse event")
+	}
+	return Event(header), nil
+}
+
+// replyError is the error of a command/reply or api/response, nil when it is +OK.
+func replyError(text string) error {
+	if strings.HasPrefix(text, "-ERR") {
+		return errors.New(strings.TrimSpace(strings.TrimPrefix(text, "-ERR")))
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+// Package esl connects to the event socket of FreeSWITCH to follow calls as they happen,
+// before the cdr is flushed.
+package esl
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// MustRegister starts a client of the event socket at addr with the app, publishing the channel
+// events of the activities onto them. It returns nil when addr is empty, ESL not configured.
+func MustRegister(app core.App, addr, password string) *Client {
+	if addr == "" {
+		app.Logger().Info("esl addr not set, skipping live call events")
+		return nil
+	}
+
+	c := New(addr, password, app.Logger())
+	err := c.Subscribe("variable_"+ActivityVar, "/^.+$/", func(e Event) {
+		if err := Publish(app, e); err != nil {
+			app.Logger().Warn("publish live event fail", "event", e.Name(), "uuid", e.UUID(), "err", err)
+		}
+	}, LiveEvents...)
+	if err != nil {
+		panic("esl: " + err.Error())
+	}
+
+	app.OnServe().BindFunc(func(e *core.ServeEvent) error {
+		c.Start()
+		return e.Next()
+	})
+
+	app.OnTerminate().BindFunc(func(e *core.TerminateEvent) error {
+		c.Stop()
+		app.Logger().Info("esl client stopped", "addr", addr)
+		return e.Next()
+	})
+
+	return c
+}
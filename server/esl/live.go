@@ -0,0 +1,162 @@
+package esl
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/tcmzzz/lightcall/server/callstate"
+
+	"github.com/pkg/errors"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ActivityVar is the channel variable of the activity id, exported to both legs by the dialplan.
+const ActivityVar = "activityId"
+
+// LiveEvents are the channel events published onto the activity.
+var LiveEvents = []string{"CHANNEL_PROGRESS", "CHANNEL_PROGRESS_MEDIA", "CHANNEL_ANSWER", "CHANNEL_BRIDGE", "CHANNEL_HANGUP"}
+
+const (
+	LiveRinging  = "ringing"
+	LiveAnswered = "answered"
+	LiveBridged  = "bridged"
+	LiveHangup   = "hangup"
+)
+
+// liveRank orders the states, a state never goes back.
+var liveRank = map[string]int{"": 0, LiveRinging: 1, LiveAnswered: 2, LiveBridged: 3, LiveHangup: 4}
+
+// Live is the state of the call reported by ESL before the cdr arrives, saved in rawlog.live.
+type Live struct {
+	State    string `json:"state"`    // ringing/answered/bridged/hangup
+	UUID     string `json:"uuid"`     // channel uuid of the a-leg, from the browser
+	BLeg     string `json:"bleg"`     // channel uuid of the b-leg, to the gateway, the last attempt with failover
+	Cause    string `json:"cause"`    // hangup cause of the a-leg
+	Answered bool   `json:"answered"` // the callee answered, kept after hangup
+	Updated  int64  `json:"updated"`  // unix ms of the last event applied
+}
+
+// Apply applies the event to the live state, returning whether it changed.
+// Only the hangup of the a-leg ends the call, a b-leg hangs up on each failed attempt.
+func (l *Live) Apply(e Event) bool {
+	aleg := e["Call-Direction"] == "inbound"
+	changed := false
+
+	if aleg && l.UUID == "" && e.UUID() != "" {
+		l.UUID, changed = e.UUID(), true
+	}
+	bleg := ""
+	if !aleg {
+		bleg = e.UUID()
+	} else if e.Name() == "CHANNEL_BRIDGE" {
+		bleg = e["Other-Leg-Unique-ID"]
+	}
+	if bleg != "" && bleg != l.BLeg && l.State != LiveHangup {
+		l.BLeg, changed = bleg, true
+	}
+
+	state := ""
+	switch e.Name() {
+	case "CHANNEL_PROGRESS", "CHANNEL_PROGRESS_MEDIA":
+		state = LiveRinging
+	case "CHANNEL_ANSWER":
+		state = LiveAnswered
+	case "CHANNEL_BRIDGE":
+		state = LiveBridged
+	case "CHANNEL_HANGUP":
+		if aleg {
+			state = LiveHangup
+			l.Cause = e["Hangup-Cause"]
+		}
+	}
+	if liveRank[state] > liveRank[l.State] {
+		l.State, changed = state, true
+	}
+	// the answer may be missed, the a-leg tells it answered when it hangs up
+	answered := liveRank[l.State] >= liveRank[LiveAnswered] && l.State != LiveHangup
+	if t := e["Caller-Channel-Answered-Time"]; aleg && t != "" && t != "0" {
+		answered = true
+	}
+	if answered && !l.Answered {
+		l.Answered, changed = true, true
+	}
+
+	if changed {
+		if us, err := strconv.ParseInt(e["Event-Date-Timestamp"], 10, 64); err == nil {
+			l.Updated = us / 1000
+		}
+	}
+	return changed
+}
+
+// CallState is the activity state of the live state.
+func (l Live) CallState() string {
+	switch l.State {
+	case LiveRinging:
+		return callstate.Ringing
+	case LiveAnswered, LiveBridged:
+		return callstate.Answered
+	case LiveHangup:
+		if l.Answered {
+			return callstate.Ended
+		}
+		return callstate.Failed
+	}
+	return ""
+}
+
+// Publish applies the event to rawlog.live of its activity, and moves the activity state forward.
+func Publish(app core.App, e Event) error {
+	activityID := e.Var(ActivityVar)
+	if activityID == "" {
+		return nil
+	}
+
+	return app.RunInTransaction(func(txApp core.App) error {
+		activity, err := txApp.FindRecordById("activity", activityID)
+		if err != nil {
+			return errors.Wrapf(err, "find activity %s", activityID)
+		}
+
+		str := activity.GetString("rawlog")
+		if str == "" {
+			str = "{}"
+		}
+		rawlog := map[string]json.RawMessage{}
+		if err := json.Unmarshal([]byte(str), &rawlog); err != nil {
+			return errors.Wrapf(err, "activity rawlog invalid(activity_id: %s)", activityID)
+		}
+
+		live := Live{}
+		if raw, ok := rawlog["live"]; ok {
+			if err := json.Unmarshal(raw, &live); err != nil {
+				return errors.Wrapf(err, "activity rawlog.live invalid(activity_id: %s)", activityID)
+			}
+		}
+		if !live.Apply(e) {
+			return nil
+		}
+
+		raw, err := json.Marshal(live)
+		if err != nil {
+			return err
+		}
+		rawlog["live"] = raw
+		rl, err := json.Marshal(rawlog)
+		if err != nil {
+			return err
+		}
+		activity.Set("rawlog", string(rl))
+		callstate.Transit(activity, live.CallState())
+		return txApp.Save(activity)
+	})
+}
+
+// LiveOf is rawlog.live of the activity, empty when no event arrived.
+func LiveOf(activity *core.Record) Live {
+	rawlog := struct {
+		Live Live `json:"live"`
+	}{}
+	_ = json.Unmarshal([]byte(activity.GetString("rawlog")), &rawlog)
+	return rawlog.Live
+}
@@ -0,0 +1,71 @@
+package esl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLiveApply(t *testing.T) {
+
+	aleg := func(name string, kv ...string) Event {
+		e := Event{"Event-Name": name, "Unique-ID": "a", "Call-Direction": "inbound", "Event-Date-Timestamp": "1700000000123456"}
+		for i := 0; i+1 < len(kv); i += 2 {
+			e[kv[i]] = kv[i+1]
+		}
+		return e
+	}
+	bleg := func(name, uuid string, kv ...string) Event {
+		e := Event{"Event-Name": name, "Unique-ID": uuid, "Call-Direction": "outbound"}
+		for i := 0; i+1 < len(kv); i += 2 {
+			e[kv[i]] = kv[i+1]
+		}
+		return e
+	}
+
+	l := Live{}
+	assert.True(t, l.Apply(bleg("CHANNEL_PROGRESS", "b1")))
+	assert.Equal(t, Live{State: LiveRinging, BLeg: "b1"}, l)
+
+	// the first attempt fails, failover to the next gateway
+	assert.False(t, l.Apply(bleg("CHANNEL_HANGUP", "b1", "Hangup-Cause", "USER_BUSY")))
+	assert.Equal(t, LiveRinging, l.State)
+	assert.True(t, l.Apply(bleg("CHANNEL_PROGRESS_MEDIA", "b2")))
+	assert.Equal(t, "b2", l.BLeg)
+
+	assert.True(t, l.Apply(aleg("CHANNEL_PROGRESS")))
+	assert.Equal(t, "a", l.UUID)
+	assert.Equal(t, int64(1700000000123), l.Updated)
+
+	assert.True(t, l.Apply(bleg("CHANNEL_ANSWER", "b2")))
+	assert.Equal(t, LiveAnswered, l.State)
+	assert.True(t, l.Answered)
+	assert.True(t, l.Apply(aleg("CHANNEL_BRIDGE", "Other-Leg-Unique-ID", "b2")))
+	assert.Equal(t, LiveBridged, l.State)
+
+	// never goes back
+	assert.False(t, l.Apply(aleg("CHANNEL_ANSWER")))
+	assert.Equal(t, LiveBridged, l.State)
+
+	assert.False(t, l.Apply(bleg("CHANNEL_HANGUP", "b2", "Hangup-Cause", "NORMAL_CLEARING")))
+	assert.True(t, l.Apply(aleg("CHANNEL_HANGUP", "Hangup-Cause", "NORMAL_CLEARING")))
+	assert.Equal(t, Live{State: LiveHangup, UUID: "a", BLeg: "b2", Cause: "NORMAL_CLEARING", Answered: true, Updated: 1700000000123}, l)
+	assert.Equal(t, "ended", l.CallState())
+
+	assert.False(t, l.Apply(aleg("CHANNEL_PROGRESS")))
+
+	// the callee never answers
+	l = Live{}
+	assert.True(t, l.Apply(bleg("CHANNEL_PROGRESS", "b1")))
+	assert.Equal(t, "ringing", l.CallState())
+	assert.True(t, l.Apply(aleg("CHANNEL_HANGUP", "Hangup-Cause", "NO_ANSWER", "Caller-Channel-Answered-Time", "0")))
+	assert.False(t, l.Answered)
+	assert.Equal(t, "failed", l.CallState())
+
+	// the answer missed, told by the hangup of the a-leg
+	l = Live{}
+	assert.True(t, l.Apply(aleg("CHANNEL_HANGUP", "Hangup-Cause", "NORMAL_CLEARING", "Caller-Channel-Answered-Time", "1700000000000000")))
+	assert.True(t, l.Answered)
+	assert.Equal(t, "ended", l.CallState())
+	assert.Equal(t, "", Live{}.CallState())
+}
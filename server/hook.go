@@ -3,8 +3,11 @@ package server
 import (
 	"strings"
 
+	"github.com/tcmzzz/lightcall/server/call"
 	"github.com/tcmzzz/lightcall/server/config"
+	"github.com/tcmzzz/lightcall/server/phone"
 
+	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/pocketbase/pocketbase/core"
 )
 
@@ -29,6 +32,44 @@ func initHook(app core.App, config config.Provider) {
 		return e.Next()
 	})
 
+	// 被叫号码规范为 E.164 保存在 calleeE164, 无法识别的号码 calleeValid 为 false
+	app.OnRecordCreate("task").BindFunc(func(e *core.RecordEvent) error {
+		normalizeCallee(e.App, config, e.Record)
+		return e.Next()
+	})
+	app.OnRecordUpdate("task").BindFunc(func(e *core.RecordEvent) error {
+		normalizeCallee(e.App, config, e.Record)
+		return e.Next()
+	})
+
+	// 网关号码变换规则保存前检查, 避免错误的规则在呼叫时才被发现
+	app.OnRecordValidate("outgw").BindFunc(func(e *core.RecordEvent) error {
+		errs := validation.Errors{}
+		for _, field := range []string{"transcaller", "transcallee"} {
+			if _, err := call.ParseTrans(e.Record.GetString(field)); err != nil {
+				errs[field] = validation.NewError("validation_invalid_trans", err.Error())
+			}
+		}
+		// 自定义拨号模板需能渲染为合法的 XML, 为空时使用默认模板
+		if tpl := e.Record.GetString("dialplan"); tpl != "" {
+			opts := map[string]any{}
+			if err := e.Record.UnmarshalJSONField("options", &opts); err != nil {
+				opts = nil
+			}
+			if err := call.CheckDialplan(tpl, opts); err != nil {
+				errs["dialplan"] = validation.NewError("validation_invalid_dialplan", err.Error())
+			}
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+		return e.Next()
+	})
+
+	// rawlog 由服务端写入(选定的主叫, 话单, 失败原因), FreeSWITCH 据此拨号, 只有管理员可以通过接口修改
+	app.OnRecordCreateRequest("activity").BindFunc(guardActivity)
+	app.OnRecordUpdateRequest("activity").BindFunc(guardActivity)
+
 	// clear config cache when config changed
 	app.OnRecordAfterUpdateSuccess("config").BindFunc(func(e *core.RecordEvent) error {
 		config.ClearCache()
@@ -45,18 +86,64 @@ func initHook(app core.App, config config.Provider) {
 
 		if privacy.HideNumber && !e.RequestInfo.Auth.GetBool("isAdmin") {
 
-			callee := e.Record.GetString("callee")
-			masked := ""
-			if len(callee) > 6 { // 保留前3位和后3位, 中间部分用*填充
-				prefix := callee[:3]
-				suffix := callee[len(callee)-3:]
-				masked = prefix + strings.Repeat("*", len(callee)-6) + suffix
-			} else { // 如果号码长度不足6位，全部显示为*
-				masked = strings.Repeat("*", len(callee))
-			}
-			e.Record.Set("callee", masked)
+			e.Record.Set("callee", maskNumber(e.Record.GetString("callee")))
+			e.Record.Set("calleeE164", maskNumber(e.Record.GetString("calleeE164")))
 		}
 
 		return e.Next()
 	})
 }
+
+// activityServerFields 是活动中只由服务端写入的字段. 创建时 user 须为当前用户, task 由用户填写
+var activityServerFields = []string{"state", "task", "rawlog", "user"}
+
+// guardActivity 拒绝非管理员通过接口设置或修改 activityServerFields
+func guardActivity(e *core.RecordRequestEvent) error {
+	if e.HasSuperuserAuth() || (e.Auth != nil && e.Auth.GetBool("isAdmin")) {
+		return e.Next()
+	}
+
+	original := e.Record.Original()
+	for _, field := range activityServerFields {
+		if e.Record.IsNew() && field == "task" {
+			continue
+		}
+		if e.Record.IsNew() && field == "user" {
+			if e.Auth == nil || e.Record.GetString(field) != e.Auth.Id {
+				return e.ForbiddenError("activity must be created for the user itself", nil)
+			}
+			continue
+		}
+		if e.Record.GetString(field) != original.GetString(field) {
+			return e.ForbiddenError("field "+field+" of activity is written by the server only", nil)
+		}
+	}
+	return e.Next()
+}
+
+func maskNumber(number string) string {
+	if len(number) > 6 { // 保留前3位和后3位, 中间部分用*填充
+		prefix := number[:3]
+		suffix := number[len(number)-3:]
+		return prefix + strings.Repeat("*", len(number)-6) + suffix
+	}
+	// 如果号码长度不足6位，全部显示为*
+	return strings.Repeat("*", len(number))
+}
+
+func normalizeCallee(app core.App, conf config.Provider, task *core.Record) {
+	country := ""
+	if p, err := conf.Phone(); err != nil {
+		app.Logger().Warn("failed to load phone config, use default country", "err", err)
+	} else {
+		country = p.DefaultCountry
+	}
+
+	callee := task.GetString("callee")
+	e164, err := phone.Normalize(callee, country)
+	if err != nil {
+		app.Logger().Warn("invalid callee", "task", task.Id, "callee", callee, "err", err)
+	}
+	task.Set("calleeE164", e164)
+	task.Set("calleeValid", err == nil)
+}
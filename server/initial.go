@@ -160,8 +160,25 @@ var rawInitConfig = `
     "name": "dial",
     "value": {
       "caller": {
-        "affinity": true
-      }
+        "affinity": true,
+        "strategy": "random",
+        "region": false,
+        "params": {
+          "fallback": "random",
+          "affinityDays": 0,
+          "window": 24,
+          "explore": 0.05
+        },
+        "mark": {
+          "exclude": [],
+          "penalize": []
+        }
+      },
+      "failover": {
+        "attempts": 1,
+        "causes": ["503", "403"]
+      },
+      "abandon": 30
     }
   },
   {
@@ -184,6 +201,44 @@ var rawInitConfig = `
       }
     }
   },
+  {
+    "name": "calling_hours",
+    "value": {
+      "enable": false,
+      "timezone": "Asia/Shanghai",
+      "windows": [
+        { "weekdays": [1, 2, 3, 4, 5], "start": "09:00", "end": "12:00" },
+        { "weekdays": [1, 2, 3, 4, 5], "start": "14:00", "end": "20:00" },
+        { "weekdays": [6, 7], "start": "10:00", "end": "18:00" }
+      ],
+      "holidays": [],
+      "objectives": {}
+    }
+  },
+  {
+    "name": "phone",
+    "value": {
+      "defaultCountry": "CN"
+    }
+  },
+  {
+    "name": "recording",
+    "value": {
+      "enable": true,
+      "format": "mp3",
+      "stereo": true,
+      "filename": "{date}/{activity}",
+      "objectives": {}
+    }
+  },
+  {
+    "name": "freeswitch",
+    "value": {
+      "allow": ["127.0.0.1/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"],
+      "secret": "",
+      "skew": 300
+    }
+  },
   {
     "name": "ice_servers",
     "value": []
@@ -0,0 +1,121 @@
+// Package phone normalizes phone numbers to the E.164 form, such as +8613800138000.
+package phone
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultCountry is used when no default country is configured.
+const DefaultCountry = "CN"
+
+// country is how numbers of a country are dialed.
+type country struct {
+	code  string                     // country calling code
+	trunk string                     // trunk prefix dialed before national numbers, such as 0
+	intl  string                     // international prefix, such as 00
+	valid func(national string) bool // checks the national number without trunk prefix
+}
+
+var countries = map[string]country{
+	"CN": {code: "86", trunk: "0", intl: "00", valid: validCN},
+	"HK": {code: "852", intl: "001", valid: func(n string) bool { return len(n) == 8 }},
+	"MO": {code: "853", intl: "00", valid: func(n string) bool { return len(n) == 8 }},
+	"TW": {code: "886", trunk: "0", intl: "002", valid: func(n string) bool { return len(n) >= 8 && len(n) <= 9 }},
+	"SG": {code: "65", intl: "000", valid: func(n string) bool { return len(n) == 8 }},
+	"US": {code: "1", trunk: "1", intl: "011", valid: func(n string) bool { return len(n) == 10 }},
+	"GB": {code: "44", trunk: "0", intl: "00", valid: func(n string) bool { return len(n) >= 9 && len(n) <= 10 }},
+	"JP": {code: "81", trunk: "0", intl: "010", valid: func(n string) bool { return len(n) >= 9 && len(n) <= 10 }},
+}
+
+// validCN accepts mobiles, landlines with area code, and 400/800 numbers.
+func validCN(n string) bool {
+	if n == "" || n[0] == '0' {
+		return false
+	}
+	if n[0] == '1' {
+		return (len(n) == 11 && n[1] >= '3') || (len(n) == 10 && n[1] == '0') // 10 is the Beijing area code
+	}
+	return len(n) >= 10 && len(n) <= 11
+}
+
+// Normalize returns the E.164 form of the number. Numbers without an international
+// prefix (+ or the one of the default country) are taken as national numbers of
+// the default country, an empty default country is DefaultCountry.
+// Spaces, dashes, dots and parentheses are ignored.
+func Normalize(number, defaultCountry string) (string, error) {
+
+	if defaultCountry == "" {
+		defaultCountry = DefaultCountry
+	}
+	home, ok := countries[strings.ToUpper(defaultCountry)]
+	if !ok {
+		return "", errors.Errorf("unsupported country: %s", defaultCountry)
+	}
+
+	digits, plus, err := clean(number)
+	if err != nil {
+		return "", err
+	}
+
+	var international string
+	switch {
+	case plus:
+		international = digits
+	case strings.HasPrefix(digits, home.intl):
+		international = digits[len(home.intl):]
+	case strings.HasPrefix(digits, "00"): // the most common international prefix
+		international = digits[2:]
+	default:
+		national := digits
+		if home.trunk != "" {
+			national = strings.TrimPrefix(national, home.trunk)
+		}
+		return format(home, national, number)
+	}
+
+	// no country code listed is a prefix of another, the order does not matter
+	for _, c := range countries {
+		if national, ok := strings.CutPrefix(international, c.code); ok {
+			// trunk prefix written after the country code, such as +86 010...
+			if c.trunk != "" && c.trunk != c.code {
+				national = strings.TrimPrefix(national, c.trunk)
+			}
+			return format(c, national, number)
+		}
+	}
+
+	// countries not listed, only the length is checked
+	if len(international) < 8 || len(international) > 15 || international[0] == '0' {
+		return "", errors.Errorf("invalid number: %s", number)
+	}
+	return "+" + international, nil
+}
+
+func format(c country, national, number string) (string, error) {
+	if !c.valid(national) || len(c.code)+len(national) > 15 {
+		return "", errors.Errorf("invalid number: %s", number)
+	}
+	return "+" + c.code + national, nil
+}
+
+// clean strips the separators of the number, plus tells whether it starts with +.
+func clean(number string) (digits string, plus bool, err error) {
+	sb := strings.Builder{}
+	for i, c := range strings.TrimSpace(number) {
+		switch {
+		case c >= '0' && c <= '9':
+			sb.WriteRune(c)
+		case c == '+' && i == 0:
+			plus = true
+		case c == ' ' || c == '-' || c == '.' || c == '(' || c == ')':
+		default:
+			return "", false, errors.Errorf("invalid character %q in number: %s", c, number)
+		}
+	}
+	if sb.Len() == 0 {
+		return "", false, errors.Errorf("invalid number: %s", number)
+	}
+	return sb.String(), plus, nil
+}
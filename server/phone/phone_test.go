@@ -0,0 +1,49 @@
+package phone
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+
+	cs := []struct {
+		raw      string
+		country  string
+		expected string
+	}{
+		{"010-1234567", "", ""},
+		{"010-12345678", "", "+861012345678"},
+		{"(0755) 1234 5678", "CN", "+8675512345678"},
+		{"13800138000", "CN", "+8613800138000"},
+		{"+86 138 0013 8000", "CN", "+8613800138000"},
+		{"008613800138000", "CN", "+8613800138000"},
+		{"+86 010 12345678", "CN", "+861012345678"},
+		{"400-123-4567", "CN", "+864001234567"},
+		{"10086", "CN", ""},
+		{"12345678901", "CN", ""},
+		{"+852 2123 4567", "CN", "+85221234567"},
+		{"00852 2123 4567", "CN", "+85221234567"},
+		{"+1 (212) 555-0100", "CN", "+12125550100"},
+		{"(212) 555-0100", "US", "+12125550100"},
+		{"1 212 555 0100", "us", "+12125550100"},
+		{"011 86 138 0013 8000", "US", "+8613800138000"},
+		{"+44 (0)20 7946 0018", "CN", "+442079460018"},
+		{"+49 30 123456", "CN", "+4930123456"},
+		{"+49 30", "CN", ""},
+		{"138-0013-8000 ext 1", "CN", ""},
+		{"", "CN", ""},
+		{"13800138000", "XX", ""},
+	}
+
+	for _, c := range cs {
+		out, err := Normalize(c.raw, c.country)
+		if c.expected == "" {
+			assert.Error(t, err, c.raw)
+		} else {
+			assert.Nil(t, err, c.raw)
+		}
+		assert.Equal(t, c.expected, out, c.raw)
+	}
+}
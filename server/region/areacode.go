@@ -0,0 +1,158 @@
+package region
+
+// areaCodes maps landline area codes to regions.
+// Only the common cities are listed, numbers of other cities fall back to national.
+var areaCodes = map[string]Region{
+	// 直辖市
+	"010": {"北京市", "北京市"},
+	"021": {"上海市", "上海市"},
+	"022": {"天津市", "天津市"},
+	"023": {"重庆市", "重庆市"},
+
+	// 省会及计划单列市
+	"020":  {"广东省", "广州市"},
+	"024":  {"辽宁省", "沈阳市"},
+	"025":  {"江苏省", "南京市"},
+	"027":  {"湖北省", "武汉市"},
+	"028":  {"四川省", "成都市"},
+	"029":  {"陕西省", "西安市"},
+	"0311": {"河北省", "石家庄市"},
+	"0351": {"山西省", "太原市"},
+	"0371": {"河南省", "郑州市"},
+	"0411": {"辽宁省", "大连市"},
+	"0431": {"吉林省", "长春市"},
+	"0451": {"黑龙江省", "哈尔滨市"},
+	"0471": {"内蒙古自治区", "呼和浩特市"},
+	"0531": {"山东省", "济南市"},
+	"0532": {"山东省", "青岛市"},
+	"0551": {"安徽省", "合肥市"},
+	"0571": {"浙江省", "杭州市"},
+	"0574": {"浙江省", "宁波市"},
+	"0591": {"福建省", "福州市"},
+	"0592": {"福建省", "厦门市"},
+	"0731": {"湖南省", "长沙市"},
+	"0755": {"广东省", "深圳市"},
+	"0771": {"广西壮族自治区", "南宁市"},
+	"0791": {"江西省", "南昌市"},
+	"0851": {"贵州省", "贵阳市"},
+	"0871": {"云南省", "昆明市"},
+	"0891": {"西藏自治区", "拉萨市"},
+	"0898": {"海南省", "海口市"},
+	"0931": {"甘肃省", "兰州市"},
+	"0951": {"宁夏回族自治区", "银川市"},
+	"0971": {"青海省", "西宁市"},
+	"0991": {"新疆维吾尔自治区", "乌鲁木齐市"},
+
+	// 河北
+	"0310": {"河北省", "邯郸市"},
+	"0312": {"河北省", "保定市"},
+	"0315": {"河北省", "唐山市"},
+	"0316": {"河北省", "廊坊市"},
+	"0317": {"河北省", "沧州市"},
+	"0335": {"河北省", "秦皇岛市"},
+
+	// 山西
+	"0352": {"山西省", "大同市"},
+	"0355": {"山西省", "长治市"},
+
+	// 河南
+	"0370": {"河南省", "商丘市"},
+	"0373": {"河南省", "新乡市"},
+	"0374": {"河南省", "许昌市"},
+	"0379": {"河南省", "洛阳市"},
+	"0391": {"河南省", "焦作市"},
+
+	// 辽宁 / 吉林 / 黑龙江
+	"0412": {"辽宁省", "鞍山市"},
+	"0415": {"辽宁省", "丹东市"},
+	"0416": {"辽宁省", "锦州市"},
+	"0432": {"吉林省", "吉林市"},
+	"0452": {"黑龙江省", "齐齐哈尔市"},
+	"0459": {"黑龙江省", "大庆市"},
+
+	// 江苏
+	"0510": {"江苏省", "无锡市"},
+	"0511": {"江苏省", "镇江市"},
+	"0512": {"江苏省", "苏州市"},
+	"0513": {"江苏省", "南通市"},
+	"0514": {"江苏省", "扬州市"},
+	"0515": {"江苏省", "盐城市"},
+	"0516": {"江苏省", "徐州市"},
+	"0517": {"江苏省", "淮安市"},
+	"0518": {"江苏省", "连云港市"},
+	"0519": {"江苏省", "常州市"},
+	"0523": {"江苏省", "泰州市"},
+	"0527": {"江苏省", "宿迁市"},
+
+	// 山东
+	"0533": {"山东省", "淄博市"},
+	"0535": {"山东省", "烟台市"},
+	"0536": {"山东省", "潍坊市"},
+	"0537": {"山东省", "济宁市"},
+	"0539": {"山东省", "临沂市"},
+	"0631": {"山东省", "威海市"},
+
+	// 安徽
+	"0552": {"安徽省", "蚌埠市"},
+	"0553": {"安徽省", "芜湖市"},
+	"0555": {"安徽省", "马鞍山市"},
+	"0556": {"安徽省", "安庆市"},
+
+	// 浙江
+	"0570": {"浙江省", "衢州市"},
+	"0572": {"浙江省", "湖州市"},
+	"0573": {"浙江省", "嘉兴市"},
+	"0575": {"浙江省", "绍兴市"},
+	"0576": {"浙江省", "台州市"},
+	"0577": {"浙江省", "温州市"},
+	"0578": {"浙江省", "丽水市"},
+	"0579": {"浙江省", "金华市"},
+	"0580": {"浙江省", "舟山市"},
+
+	// 福建
+	"0594": {"福建省", "莆田市"},
+	"0595": {"福建省", "泉州市"},
+	"0596": {"福建省", "漳州市"},
+	"0598": {"福建省", "三明市"},
+	"0599": {"福建省", "南平市"},
+
+	// 湖北 / 湖南
+	"0710": {"湖北省", "襄阳市"},
+	"0716": {"湖北省", "荆州市"},
+	"0717": {"湖北省", "宜昌市"},
+	"0730": {"湖南省", "岳阳市"},
+	"0732": {"湖南省", "湘潭市"},
+	"0733": {"湖南省", "株洲市"},
+	"0734": {"湖南省", "衡阳市"},
+
+	// 广东
+	"0750": {"广东省", "江门市"},
+	"0752": {"广东省", "惠州市"},
+	"0754": {"广东省", "汕头市"},
+	"0756": {"广东省", "珠海市"},
+	"0757": {"广东省", "佛山市"},
+	"0759": {"广东省", "湛江市"},
+	"0760": {"广东省", "中山市"},
+	"0769": {"广东省", "东莞市"},
+
+	// 广西 / 江西
+	"0772": {"广西壮族自治区", "柳州市"},
+	"0773": {"广西壮族自治区", "桂林市"},
+	"0792": {"江西省", "九江市"},
+	"0797": {"江西省", "赣州市"},
+
+	// 四川
+	"0813": {"四川省", "自贡市"},
+	"0816": {"四川省", "绵阳市"},
+	"0817": {"四川省", "南充市"},
+	"0831": {"四川省", "宜宾市"},
+	"0833": {"四川省", "乐山市"},
+	"0838": {"四川省", "德阳市"},
+
+	// 陕西
+	"0910": {"陕西省", "咸阳市"},
+	"0911": {"陕西省", "延安市"},
+	"0912": {"陕西省", "榆林市"},
+	"0913": {"陕西省", "渭南市"},
+	"0917": {"陕西省", "宝鸡市"},
+}
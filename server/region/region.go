@@ -0,0 +1,159 @@
+package region
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Region is where a phone number is located, in the same form as number.tag.
+type Region struct {
+	Province string `json:"province"`
+	City     string `json:"city"`
+}
+
+var (
+	mu sync.RWMutex
+	// mobiles maps the first 7 digits of a mobile number to its region.
+	mobiles = map[string]Region{}
+)
+
+// LoadMobileFile loads mobile segments from a csv file, one `prefix,province,city` per line.
+// Lines starting with # are ignored. Segments already loaded are replaced.
+func LoadMobileFile(file string) error {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return errors.Wrapf(err, "open mobile segment file fail(%s)", file)
+	}
+	defer f.Close()
+
+	m := map[string]Region{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		cols := strings.Split(text, ",")
+		if len(cols) != 3 || len(cols[0]) != 7 {
+			return errors.Errorf("invalid mobile segment(%s:%d): %s", file, line, text)
+		}
+		m[cols[0]] = Region{Province: strings.TrimSpace(cols[1]), City: strings.TrimSpace(cols[2])}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "read mobile segment file fail(%s)", file)
+	}
+
+	mu.Lock()
+	mobiles = m
+	mu.Unlock()
+	return nil
+}
+
+// Mobiles is the number of mobile segments loaded, 0 when no segment file is loaded.
+func Mobiles() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(mobiles)
+}
+
+// Lookup finds the region of a mobile or landline number.
+// Separators and the +86/0086 country code are ignored.
+func Lookup(number string) (Region, bool) {
+
+	n := Digits(number)
+
+	if len(n) == 11 && n[0] == '1' {
+		mu.RLock()
+		r, ok := mobiles[n[:7]]
+		mu.RUnlock()
+		return r, ok
+	}
+
+	if len(n) > 3 && n[0] == '0' {
+		if r, ok := areaCodes[n[:3]]; ok {
+			return r, true
+		}
+		if r, ok := areaCodes[n[:4]]; ok {
+			return r, true
+		}
+	}
+
+	return Region{}, false
+}
+
+// Digits strips everything but digits from the number,
+// and removes the 86 country code in front of a mobile or landline number.
+func Digits(number string) string {
+
+	sb := strings.Builder{}
+	for _, c := range number {
+		if c >= '0' && c <= '9' {
+			sb.WriteRune(c)
+		}
+	}
+	n := sb.String()
+
+	for _, cc := range []string{"0086", "86"} {
+		rest, ok := strings.CutPrefix(n, cc)
+		if !ok {
+			continue
+		}
+		if len(rest) == 11 && rest[0] == '1' && rest[1] >= '3' {
+			return rest
+		}
+		if len(rest) >= 9 && len(rest) <= 11 && rest[0] != '0' {
+			return "0" + rest
+		}
+	}
+	return n
+}
+
+// Number classes returned by Class.
+const (
+	ClassMobile   = "mobile"
+	ClassLandline = "landline"
+	ClassShort    = "short"
+)
+
+// Class tells whether the number is a mobile, a landline with or without
+// area code, or a short number such as 10086 and 95588. It returns "" for others.
+func Class(number string) string {
+	n := Digits(number)
+	switch {
+	case len(n) == 11 && n[0] == '1' && n[1] >= '3':
+		return ClassMobile
+	case len(n) >= 10 && len(n) <= 12 && n[0] == '0':
+		return ClassLandline
+	case len(n) >= 7 && len(n) <= 8 && n[0] != '0' && n[0] != '1':
+		return ClassLandline
+	case len(n) >= 3 && len(n) <= 6:
+		return ClassShort
+	}
+	return ""
+}
+
+// SameProvince reports whether both names refer to the same province,
+// so "北京" and "北京市" are the same.
+func SameProvince(a, b string) bool {
+	return a != "" && trimSuffix(a) == trimSuffix(b)
+}
+
+// SameCity reports whether both names refer to the same city.
+func SameCity(a, b string) bool {
+	return a != "" && trimSuffix(a) == trimSuffix(b)
+}
+
+func trimSuffix(name string) string {
+	name = strings.TrimSpace(name)
+	for _, s := range []string{"壮族自治区", "回族自治区", "维吾尔自治区", "自治区", "特别行政区", "省", "市"} {
+		if n, ok := strings.CutSuffix(name, s); ok && n != "" {
+			return n
+		}
+	}
+	return name
+}
@@ -0,0 +1,86 @@
+package region
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDigits(t *testing.T) {
+
+	cs := []struct {
+		raw      string
+		expected string
+	}{
+		{"010-1234567", "0101234567"},
+		{"+86 138 0013 8000", "13800138000"},
+		{"008613800138000", "13800138000"},
+		{"+86 10 12345678", "01012345678"},
+		{"+86 755 1234 5678", "075512345678"},
+		{"1234567", "1234567"},
+	}
+
+	for _, c := range cs {
+		assert.Equal(t, c.expected, Digits(c.raw), c.raw)
+	}
+}
+
+func TestClass(t *testing.T) {
+
+	cs := []struct {
+		raw      string
+		expected string
+	}{
+		{"+86 138 0013 8000", ClassMobile},
+		{"010-1234567", ClassLandline},
+		{"0755-12345678", ClassLandline},
+		{"+86 10 12345678", ClassLandline},
+		{"12345678", ""},
+		{"62345678", ClassLandline},
+		{"10086", ClassShort},
+		{"95588", ClassShort},
+		{"110", ClassShort},
+		{"12", ""},
+		{"4001234567", ""},
+	}
+
+	for _, c := range cs {
+		assert.Equal(t, c.expected, Class(c.raw), c.raw)
+	}
+}
+
+func TestLookup(t *testing.T) {
+
+	file := path.Join(t.TempDir(), "mobile.csv")
+	assert.Nil(t, os.WriteFile(file, []byte("# prefix,province,city\n1380013,北京市,北京市\n"), 0600))
+	assert.Nil(t, LoadMobileFile(file))
+	assert.Equal(t, 1, Mobiles())
+
+	cs := []struct {
+		raw      string
+		expected Region
+		found    bool
+	}{
+		{"010-1234567", Region{"北京市", "北京市"}, true},
+		{"0755-12345678", Region{"广东省", "深圳市"}, true},
+		{"029 8888 8888", Region{"陕西省", "西安市"}, true},
+		{"+86 138 0013 8000", Region{"北京市", "北京市"}, true},
+		{"13900139000", Region{}, false},
+		{"1234567", Region{}, false},
+	}
+
+	for _, c := range cs {
+		r, ok := Lookup(c.raw)
+		assert.Equal(t, c.found, ok, c.raw)
+		assert.Equal(t, c.expected, r, c.raw)
+	}
+}
+
+func TestSameProvince(t *testing.T) {
+	assert.True(t, SameProvince("北京", "北京市"))
+	assert.True(t, SameProvince("广西壮族自治区", "广西"))
+	assert.False(t, SameProvince("", ""))
+	assert.False(t, SameProvince("陕西省", "山西省"))
+}
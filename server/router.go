@@ -7,12 +7,13 @@ import (
 	"github.com/tcmzzz/lightcall/server/cloud/mock"
 	"github.com/tcmzzz/lightcall/server/cloud/precall"
 	"github.com/tcmzzz/lightcall/server/config"
+	"github.com/tcmzzz/lightcall/server/esl"
 
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
 )
 
-func initRouter(app core.App, config config.Provider) {
+func initRouter(app core.App, config config.Provider, eslClient *esl.Client) {
 
 	if app.IsDev() {
 
@@ -33,11 +34,22 @@ func initRouter(app core.App, config config.Provider) {
 
 		g := se.Router.Group("/api/custom/call")
 
-		g.GET("/new/{id}", call.HandleCreateActivity).Bind(apis.RequireAuth())
+		g.GET("/new/{id}", call.HandleCreateActivity(config)).Bind(apis.RequireAuth())
+		g.GET("/plan/{taskId}", call.HandleCallPlan(config)).Bind(apis.RequireAuth())
 		g.GET("/precall/blacklist/{activityId}", call.HandlePreCall(config, precall.BlackList))
 		g.GET("/precall/flashcard/{activityId}", call.HandlePreCall(config, precall.FlashCard))
 		g.POST("/direct", call.HandleDirectCall).Bind(apis.RequireAuth())
-		g.POST("/sip/fs", call.HandleFsCall) // TODO: check fs ip
+		g.POST("/trans/preview", call.HandleTransPreview).Bind(apis.RequireAuth())
+		g.GET("/trans/schema", call.HandleTransSchema).Bind(apis.RequireAuth())
+		g.POST("/sip/fs", call.HandleFsCall(config)).BindFunc(call.RequireFreeSwitch(config))
+		g.POST("/sip/fs/configuration", call.HandleFsConfiguration).BindFunc(call.RequireFreeSwitch(config))
+		g.POST("/sip/fs/directory", call.HandleFsDirectory).BindFunc(call.RequireFreeSwitch(config))
+		g.GET("/sip/credential", call.HandleSipCredential).Bind(apis.RequireAuth())
+		g.POST("/{activityId}/hangup", call.HandleCallControl(eslClient, call.ControlHangup)).Bind(apis.RequireAuth())
+		g.POST("/{activityId}/hold", call.HandleCallControl(eslClient, call.ControlHold)).Bind(apis.RequireAuth())
+		g.POST("/{activityId}/unhold", call.HandleCallControl(eslClient, call.ControlUnhold)).Bind(apis.RequireAuth())
+		g.POST("/{activityId}/dtmf", call.HandleCallControl(eslClient, call.ControlDtmf)).Bind(apis.RequireAuth())
+		g.POST("/{activityId}/monitor", call.HandleMonitor(eslClient)).Bind(apis.RequireAuth())
 
 		return se.Next()
 	})
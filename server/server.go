@@ -4,7 +4,11 @@ import (
 	"github.com/tcmzzz/lightcall/server/appender"
 	"github.com/tcmzzz/lightcall/server/appender/activity"
 	"github.com/tcmzzz/lightcall/server/appender/change"
+	"github.com/tcmzzz/lightcall/server/call"
 	"github.com/tcmzzz/lightcall/server/config"
+	"github.com/tcmzzz/lightcall/server/esl"
+	"github.com/tcmzzz/lightcall/server/region"
+	"github.com/tcmzzz/lightcall/server/stats"
 	"github.com/tcmzzz/lightcall/server/tail"
 	"github.com/tcmzzz/lightcall/server/tail/cdc"
 	"github.com/tcmzzz/lightcall/server/tail/fs"
@@ -18,15 +22,35 @@ type FilePath struct {
 	TailChange     string
 	AppendActivity string
 	AppendChange   string
+	RegionMobile   string
 }
 
-func MustRegister(app core.App, path *FilePath) {
+// EslConf is the event socket of FreeSWITCH, ESL is not used when Addr is empty.
+type EslConf struct {
+	Addr     string
+	Password string
+}
+
+func MustRegister(app core.App, path *FilePath, eslConf *EslConf) {
 
 	configProvider := config.New(app)
 
+	if path.RegionMobile != "" {
+		if err := region.LoadMobileFile(path.RegionMobile); err != nil {
+			app.Logger().Error("加载号段文件失败", "file", path.RegionMobile, "error", err)
+			panic("region: 加载号段文件失败 - " + err.Error())
+		}
+		app.Logger().Info("加载号段文件", "file", path.RegionMobile, "count", region.Mobiles())
+	}
+
+	stats.MustRegister(app)
+
 	initData(app)
+	warnRegion(app, configProvider)
 	initHook(app, configProvider)
-	initRouter(app, configProvider)
+	eslClient := esl.MustRegister(app, eslConf.Addr, eslConf.Password)
+	initRouter(app, configProvider, eslClient)
+	call.MustRegisterReaper(app, configProvider)
 
 	appender.MustRegister(app, &activity.Handler{LogFile: path.AppendActivity})
 	appender.MustRegister(app, &change.Handler{LogFile: path.AppendChange})
@@ -34,3 +58,14 @@ func MustRegister(app core.App, path *FilePath) {
 	tail.MustRegister(app, &fs.Handler{MasterFile: path.TailFsCDR, RecordDir: path.FsRecordDir})
 	tail.MustRegister(app, &cdc.Handler{CdcFile: path.TailChange})
 }
+
+// warnRegion 在开启了按归属地选择主叫(dial.caller.region)但没有加载号段文件时告警, 此时手机号无法识别归属地
+func warnRegion(app core.App, conf config.Provider) {
+	app.OnServe().BindFunc(func(e *core.ServeEvent) error {
+		dial, err := conf.Dial()
+		if err == nil && dial.Caller.Region && region.Mobiles() == 0 {
+			e.App.Logger().Warn("dial.caller.region 已开启, 但未配置号段文件(regionMobileFile), 手机号无法识别归属地, 只有固话被叫按归属地选择主叫")
+		}
+		return e.Next()
+	})
+}
@@ -0,0 +1,156 @@
+package stats
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Retention is how long outcomes are kept, the longest window that can be queried.
+const Retention = 7 * 24 * time.Hour
+
+var (
+	// Numbers tracks whether the callee answered, keyed by caller number.
+	Numbers = NewTracker(Retention)
+	// Gateways tracks whether the carrier delivered the call, keyed by outgw id.
+	Gateways = NewTracker(Retention)
+)
+
+// Add records the outcome of the finished call of an activity.
+func Add(activityID, number, gateway string, at time.Time, connectOK, providerOK bool) {
+	if number != "" {
+		Numbers.Add(number, activityID, at, connectOK)
+	}
+	if gateway != "" {
+		Gateways.Add(gateway, activityID, at, providerOK)
+	}
+}
+
+// AddFailedAttempt records a failover attempt of the activity that failed before the one bridged last,
+// the callee never answered it.
+func AddFailedAttempt(activityID string, attempt int, number, gateway string, at time.Time, providerOK bool) {
+	Add(fmt.Sprintf("%s#%d", activityID, attempt), number, gateway, at, false, providerOK)
+}
+
+type outcome struct {
+	id string
+	at time.Time
+	ok bool
+}
+
+// Tracker counts outcomes per key over a sliding window.
+type Tracker struct {
+	mu        sync.Mutex
+	retention time.Duration
+	outcomes  map[string][]outcome
+}
+
+func NewTracker(retention time.Duration) *Tracker {
+	return &Tracker{retention: retention, outcomes: map[string][]outcome{}}
+}
+
+// Add records an outcome for the key and drops the ones out of retention.
+// An outcome with the same id replaces the previous one, as CDRs may be replayed.
+func (t *Tracker) Add(key, id string, at time.Time, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := t.outcomes[key]
+	for i := range list {
+		if list[i].id == id {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	list = append(list, outcome{id: id, at: at, ok: ok})
+
+	// CDRs land roughly in order, keep the list sorted by time
+	for i := len(list) - 1; i > 0 && list[i].at.Before(list[i-1].at); i-- {
+		list[i], list[i-1] = list[i-1], list[i]
+	}
+
+	expired := time.Now().Add(-t.retention)
+	drop := 0
+	for drop < len(list) && list[drop].at.Before(expired) {
+		drop++
+	}
+	t.outcomes[key] = list[drop:]
+}
+
+// Count returns the successful and total outcomes of the key since the given time.
+func (t *Tracker) Count(key string, since time.Time) (ok, total int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := t.outcomes[key]
+	for i := len(list) - 1; i >= 0 && !list[i].at.Before(since); i-- {
+		total++
+		if list[i].ok {
+			ok++
+		}
+	}
+	return
+}
+
+// Rate is the Laplace-smoothed success rate of the key since the given time,
+// so a key without outcomes has a rate of 0.5.
+func (t *Tracker) Rate(key string, since time.Time) float64 {
+	ok, total := t.Count(key, since)
+	return float64(ok+1) / float64(total+2)
+}
+
+// MustRegister loads the outcomes within retention from the activity collection on serve.
+func MustRegister(app core.App) {
+
+	app.OnServe().BindFunc(func(e *core.ServeEvent) error {
+
+		since := time.Now().Add(-Retention).Unix()
+		activities, err := e.App.FindRecordsByFilter(
+			"activity",
+			"isCall = true && rawlog.state.start_epoch >= {:since}",
+			"",
+			0,
+			0,
+			dbx.Params{"since": since},
+		)
+		if err != nil {
+			e.App.Logger().Error("加载接通率统计失败", "error", err)
+			return e.Next()
+		}
+
+		for _, a := range activities {
+			var rawlog struct {
+				Call struct {
+					OriCaller string
+					Gateway   string
+				} `json:"call"`
+				State struct {
+					ProviderOK bool  `json:"provider_ok"`
+					ConnectOK  bool  `json:"connect_ok"`
+					StartEpoch int64 `json:"start_epoch"`
+					Failed     []struct {
+						Attempt    int    `json:"attempt"`
+						Caller     string `json:"caller"`
+						Gateway    string `json:"gateway"`
+						ProviderOK bool   `json:"provider_ok"`
+						StartEpoch int64  `json:"start_epoch"`
+					} `json:"failed"`
+				} `json:"state"`
+			}
+			if err := a.UnmarshalJSONField("rawlog", &rawlog); err != nil {
+				continue
+			}
+			Add(a.Id, rawlog.Call.OriCaller, rawlog.Call.Gateway, time.Unix(rawlog.State.StartEpoch, 0),
+				rawlog.State.ConnectOK, rawlog.State.ProviderOK)
+			for _, f := range rawlog.State.Failed {
+				AddFailedAttempt(a.Id, f.Attempt, f.Caller, f.Gateway, time.Unix(f.StartEpoch, 0), f.ProviderOK)
+			}
+		}
+
+		e.App.Logger().Info("接通率统计已加载", "activities", len(activities))
+		return e.Next()
+	})
+}
@@ -0,0 +1,51 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker(t *testing.T) {
+
+	tr := NewTracker(24 * time.Hour)
+	now := time.Now()
+
+	tr.Add("n1", "a1", now.Add(-2*time.Hour), true)
+	tr.Add("n1", "a2", now.Add(-30*time.Hour), true) // out of retention
+	tr.Add("n1", "a3", now.Add(-time.Hour), false)
+	tr.Add("n1", "a4", now.Add(-3*time.Hour), false)
+	tr.Add("n1", "a3", now.Add(-time.Hour), true) // replayed
+
+	ok, total := tr.Count("n1", now.Add(-24*time.Hour))
+	assert.Equal(t, 2, ok)
+	assert.Equal(t, 3, total)
+
+	ok, total = tr.Count("n1", now.Add(-150*time.Minute))
+	assert.Equal(t, 2, ok)
+	assert.Equal(t, 2, total)
+
+	assert.Equal(t, 0.5, tr.Rate("n2", now.Add(-time.Hour)))
+	assert.InDelta(t, 0.6, tr.Rate("n1", now.Add(-24*time.Hour)), 0.001)
+}
+
+func TestAddFailedAttempt(t *testing.T) {
+
+	at := time.Now().Add(-time.Hour)
+
+	// the attempt rejected by gw1 counts against it, even though the call connected on gw2
+	AddFailedAttempt("a1", 1, "failed-n1", "failed-gw1", at, false)
+	Add("a1", "failed-n2", "failed-gw2", at, true, true)
+	AddFailedAttempt("a1", 1, "failed-n1", "failed-gw1", at, false) // replayed
+
+	ok, total := Gateways.Count("failed-gw1", at.Add(-time.Minute))
+	assert.Equal(t, 0, ok)
+	assert.Equal(t, 1, total)
+	ok, total = Numbers.Count("failed-n1", at.Add(-time.Minute))
+	assert.Equal(t, 0, ok)
+	assert.Equal(t, 1, total)
+	ok, total = Gateways.Count("failed-gw2", at.Add(-time.Minute))
+	assert.Equal(t, 1, ok)
+	assert.Equal(t, 1, total)
+}
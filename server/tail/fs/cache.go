@@ -14,6 +14,6 @@ var (
 
 	// alegCache stores aleg records, keyed by aleg.UUID.
 	alegCache = cache.New(defaultExpiration, cleanupInterval)
-	// blegCache stores bleg records, keyed by bleg.Originator.
+	// blegCache stores bleg records of every attempt, keyed by bleg.Originator.
 	blegCache = cache.New(defaultExpiration, cleanupInterval)
 )
@@ -4,9 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/tcmzzz/lightcall/server/callstate"
+	"github.com/tcmzzz/lightcall/server/stats"
+
 	"github.com/pkg/errors"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/tools/filesystem"
@@ -33,6 +37,12 @@ type CdrLine struct {
 	RealCaller           string `json:"realCaller"`
 	RealCallee           string `json:"realCallee"`
 	Record               string `json:"record"`
+	Attempt              string `json:"attempt"` // lc_attempt, failover attempt bridged, from 1
+}
+
+func (l *CdrLine) attempt() int {
+	n, _ := strconv.Atoi(l.Attempt)
+	return n
 }
 
 type State struct {
@@ -47,6 +57,45 @@ type State struct {
 	ALegSipTerm string `json:"a_leg_sip_term"`
 	BLegCause   string `json:"b_leg_cause"`
 	BLegSipTerm string `json:"b_leg_sip_term"`
+	Attempt     int    `json:"attempt"` // failover attempt of the bleg, 0 when unknown
+
+	Failed []AttemptState `json:"failed,omitempty"` // failover attempts failed before the bleg
+}
+
+// AttemptState is a failover attempt that failed before the one bridged last.
+type AttemptState struct {
+	Attempt     int    `json:"attempt"`
+	Caller      string `json:"caller"`  // OriCaller of the attempt
+	Gateway     string `json:"gateway"` // outgw id of the attempt
+	ProviderOK  bool   `json:"provider_ok"`
+	StartEpoch  int64  `json:"start_epoch"`
+	BLegCause   string `json:"b_leg_cause"`
+	BLegSipTerm string `json:"b_leg_sip_term"`
+}
+
+// failedAttempts are the states of the failed blegs, by the attempts kept in rawlog.
+// Blegs without an attempt of the activity are left out, their number and gateway are unknown.
+func failedAttempts(attempts []interface{}, failed []*CdrLine) []AttemptState {
+	ret := make([]AttemptState, 0, len(failed))
+	for _, bleg := range failed {
+		n := bleg.attempt()
+		if n <= 0 || n > len(attempts) {
+			continue
+		}
+		call, _ := attempts[n-1].(map[string]interface{})
+		caller, _ := call["OriCaller"].(string)
+		gateway, _ := call["Gateway"].(string)
+		ret = append(ret, AttemptState{
+			Attempt:     n,
+			Caller:      caller,
+			Gateway:     gateway,
+			ProviderOK:  bleg.ProgressMediaEpoch != 0,
+			StartEpoch:  bleg.StartEpoch,
+			BLegCause:   bleg.HangupCause,
+			BLegSipTerm: bleg.SipTermStatus,
+		})
+	}
+	return ret
 }
 
 func (s *State) Comment() string { // 电话开始于 2024-09-13 13:22, 总用时 5 分钟
@@ -96,10 +145,13 @@ func GenerateCallState(aleg, bleg *CdrLine) (*State, error) {
 		ALegSipTerm: aleg.SipTermStatus,
 		BLegCause:   bleg.HangupCause,
 		BLegSipTerm: bleg.SipTermStatus,
+		Attempt:     bleg.attempt(),
 	}, nil
 }
 
-func (l *CdrLine) LoadWithBleg(app core.App, recordDir string, bleg *CdrLine) error {
+// LoadWithBleg saves the call of the aleg bridged to bleg on its activity, failed are the blegs
+// of the failover attempts failed before, counted in the connect rates as the bleg is.
+func (l *CdrLine) LoadWithBleg(app core.App, recordDir string, bleg *CdrLine, failed []*CdrLine) error {
 
 	record, err := app.FindRecordById("activity", l.ActivityID)
 	if err != nil {
@@ -127,6 +179,25 @@ func (l *CdrLine) LoadWithBleg(app core.App, recordDir string, bleg *CdrLine) er
 	if err := json.Unmarshal([]byte(str), &rawlog); err != nil {
 		return errors.Wrapf(err, "activity rawlog invalid(activity_id: %s).", l.ActivityID)
 	}
+	// the attempt bridged last is the call actually made
+	attempts, _ := rawlog["attempts"].([]interface{})
+	if state.Attempt > 0 && state.Attempt <= len(attempts) {
+		rawlog["call"] = attempts[state.Attempt-1]
+	}
+	state.Failed = failedAttempts(attempts, failed)
+
+	var gateway string
+	if call, ok := rawlog["call"].(map[string]interface{}); ok {
+		gateway, _ = call["Gateway"].(string)
+	}
+
+	// the recording planned for the activity, empty when not recorded.
+	// calls dialed before it was planned use the file FreeSWITCH reports.
+	recordFile := l.Record
+	if recording, ok := rawlog["recording"].(map[string]interface{}); ok {
+		recordFile, _ = recording["file"].(string)
+	}
+
 	rawlog["fslega"] = l
 	rawlog["fslegb"] = bleg
 	rawlog["state"] = state
@@ -145,10 +216,19 @@ func (l *CdrLine) LoadWithBleg(app core.App, recordDir string, bleg *CdrLine) er
 		"updated": created,
 		"isCall":  true,
 	})
-
+	if record.GetString("task") == "" {
+		record.Set("task", task.Id)
+	}
+	// the cdr ends the call, unless ESL told it first
 	if state.ConnectOK {
+		callstate.Transit(record, callstate.Ended)
+	} else {
+		callstate.Transit(record, callstate.Failed)
+	}
 
-		rFile, err := filesystem.NewFileFromPath(path.Join(recordDir, l.Record))
+	if state.ConnectOK && recordFile != "" {
+
+		rFile, err := filesystem.NewFileFromPath(path.Join(recordDir, recordFile))
 		if err != nil {
 			app.Logger().Warn("record file fail", "error", err)
 		} else {
@@ -156,7 +236,7 @@ func (l *CdrLine) LoadWithBleg(app core.App, recordDir string, bleg *CdrLine) er
 		}
 	}
 
-	return app.RunInTransaction(func(txApp core.App) error {
+	err = app.RunInTransaction(func(txApp core.App) error {
 
 		if err := txApp.Save(record); err != nil {
 			return errors.Wrap(err, "save record fail")
@@ -167,4 +247,13 @@ func (l *CdrLine) LoadWithBleg(app core.App, recordDir string, bleg *CdrLine) er
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	stats.Add(record.Id, state.Caller, gateway, time.Unix(state.StartEpoch, 0), state.ConnectOK, state.ProviderOK)
+	for _, a := range state.Failed {
+		stats.AddFailedAttempt(record.Id, a.Attempt, a.Caller, a.Gateway, time.Unix(a.StartEpoch, 0), a.ProviderOK)
+	}
+	return nil
 }
@@ -14,8 +14,15 @@ type Handler struct {
 
 func (c *Handler) File() string { return c.MasterFile }
 
-func processMatchedCdr(app core.App, recordDir string, aleg *CdrLine, bleg *CdrLine) error {
-	return aleg.LoadWithBleg(app, recordDir, bleg)
+// processMatchedCdr loads the aleg with the bleg bridged last, the other blegs are of the failover attempts failed before.
+func processMatchedCdr(app core.App, recordDir string, aleg *CdrLine, bleg *CdrLine, blegs []*CdrLine) error {
+	failed := make([]*CdrLine, 0, len(blegs))
+	for _, b := range blegs {
+		if b != bleg {
+			failed = append(failed, b)
+		}
+	}
+	return aleg.LoadWithBleg(app, recordDir, bleg, failed)
 }
 
 func (c *Handler) Deal(app core.App, line string) error {
@@ -39,21 +46,17 @@ func (c *Handler) Deal(app core.App, line string) error {
 }
 
 func processALeg(app core.App, recordDir string, cdrLine *CdrLine) error {
-	// Check if a bleg is waiting for this aleg
-	blegRaw, found := blegCache.Get(cdrLine.UUID)
-	if !found {
+	// Check if the bleg of this aleg is waiting
+	blegs := cachedBlegs(cdrLine.UUID)
+	bleg := matchBleg(cdrLine, blegs)
+	if bleg == nil {
 		// No bleg found, cache this aleg
 		alegCache.Set(cdrLine.UUID, cdrLine, cache.DefaultExpiration)
 		return nil
 	}
 
-	bleg, ok := blegRaw.(*CdrLine)
-	if !ok {
-		return nil
-	}
-
 	// Found a match, process them
-	if err := processMatchedCdr(app, recordDir, cdrLine, bleg); err != nil {
+	if err := processMatchedCdr(app, recordDir, cdrLine, bleg, blegs); err != nil {
 		return err
 	}
 	blegCache.Delete(cdrLine.UUID)
@@ -63,21 +66,45 @@ func processALeg(app core.App, recordDir string, cdrLine *CdrLine) error {
 func processBLeg(app core.App, recordDir string, cdrLine *CdrLine) error {
 	// Check if an aleg is waiting for this bleg
 	alegRaw, found := alegCache.Get(cdrLine.Originator)
-	if !found {
-		// No aleg found, cache this bleg
-		blegCache.Set(cdrLine.Originator, cdrLine, cache.DefaultExpiration)
-		return nil
-	}
-
 	aleg, ok := alegRaw.(*CdrLine)
-	if !ok {
+	if !found || !ok || matchBleg(aleg, []*CdrLine{cdrLine}) == nil {
+		// No aleg found, or this is a failed attempt before the last one, cache this bleg
+		blegCache.Set(cdrLine.Originator, append(cachedBlegs(cdrLine.Originator), cdrLine), cache.DefaultExpiration)
 		return nil
 	}
 
 	// Found a match, process them
-	if err := processMatchedCdr(app, recordDir, aleg, cdrLine); err != nil {
+	if err := processMatchedCdr(app, recordDir, aleg, cdrLine, cachedBlegs(cdrLine.Originator)); err != nil {
 		return err
 	}
 	alegCache.Delete(cdrLine.Originator)
+	blegCache.Delete(cdrLine.Originator)
+	return nil
+}
+
+func cachedBlegs(originator string) []*CdrLine {
+	blegsRaw, found := blegCache.Get(originator)
+	if !found {
+		return nil
+	}
+	blegs, _ := blegsRaw.([]*CdrLine)
+	return blegs
+}
+
+// matchBleg finds the bleg of the last attempt the aleg bridged.
+// With failover an aleg has a bleg per attempt, the failed ones end before the aleg.
+// Without attempt numbers in cdr, the latest bleg is used.
+func matchBleg(aleg *CdrLine, blegs []*CdrLine) *CdrLine {
+	if len(blegs) == 0 {
+		return nil
+	}
+	if aleg.attempt() == 0 {
+		return blegs[len(blegs)-1]
+	}
+	for _, bleg := range blegs {
+		if bleg.attempt() == aleg.attempt() {
+			return bleg
+		}
+	}
 	return nil
 }
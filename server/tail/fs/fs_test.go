@@ -0,0 +1,40 @@
+package fs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchBleg(t *testing.T) {
+	b1 := &CdrLine{UUID: "b1", Originator: "a", Attempt: "1"}
+	b2 := &CdrLine{UUID: "b2", Originator: "a", Attempt: "2"}
+
+	// failover: wait for the bleg of the last attempt
+	aleg := &CdrLine{UUID: "a", Attempt: "2"}
+	assert.Nil(t, matchBleg(aleg, nil))
+	assert.Nil(t, matchBleg(aleg, []*CdrLine{b1}))
+	assert.Equal(t, b2, matchBleg(aleg, []*CdrLine{b1, b2}))
+
+	// no attempt in cdr: the latest bleg
+	legacy := &CdrLine{UUID: "a"}
+	assert.Equal(t, b2, matchBleg(legacy, []*CdrLine{b1, b2}))
+	assert.Nil(t, matchBleg(legacy, nil))
+}
+
+func TestFailedAttempts(t *testing.T) {
+	attempts := []interface{}{
+		map[string]interface{}{"OriCaller": "100", "Gateway": "gw1"},
+		map[string]interface{}{"OriCaller": "200", "Gateway": "gw2"},
+	}
+	failed := []*CdrLine{
+		{UUID: "b1", Originator: "a", Attempt: "1", StartEpoch: 10, HangupCause: "NORMAL_TEMPORARY_FAILURE", SipTermStatus: "503"},
+		{UUID: "bx", Originator: "a"},               // no attempt
+		{UUID: "b9", Originator: "a", Attempt: "9"}, // not kept
+	}
+
+	assert.Equal(t, []AttemptState{{
+		Attempt: 1, Caller: "100", Gateway: "gw1", StartEpoch: 10, BLegCause: "NORMAL_TEMPORARY_FAILURE", BLegSipTerm: "503",
+	}}, failedAttempts(attempts, failed))
+	assert.Empty(t, failedAttempts(nil, failed))
+}
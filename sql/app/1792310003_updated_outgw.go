@@ -0,0 +1,81 @@
+package app
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("ycsc8065tca55i6")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(8, []byte(`{
+			"hidden": false,
+			"id": "number1076352641",
+			"max": null,
+			"min": 0,
+			"name": "maxConcurrent",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(9, []byte(`{
+			"hidden": false,
+			"id": "number3897710528",
+			"max": null,
+			"min": 0,
+			"name": "maxPerHour",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(10, []byte(`{
+			"hidden": false,
+			"id": "number2984407213",
+			"max": null,
+			"min": 0,
+			"name": "maxPerDay",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("ycsc8065tca55i6")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("number1076352641")
+
+		// remove field
+		collection.Fields.RemoveById("number3897710528")
+
+		// remove field
+		collection.Fields.RemoveById("number2984407213")
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3720157081")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(7, []byte(`{
+			"cascadeDelete": false,
+			"collectionId": "94k0bun8cz1ufxl",
+			"hidden": false,
+			"id": "relation1553826349",
+			"maxSelect": 999,
+			"minSelect": 0,
+			"name": "numbers",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "relation"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(8, []byte(`{
+			"hidden": false,
+			"id": "json1840241857",
+			"maxSize": 0,
+			"name": "numberTag",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "json"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3720157081")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("relation1553826349")
+
+		// remove field
+		collection.Fields.RemoveById("json1840241857")
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("_pb_users_auth_")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(10, []byte(`{
+			"cascadeDelete": false,
+			"collectionId": "94k0bun8cz1ufxl",
+			"hidden": false,
+			"id": "relation2710458623",
+			"maxSelect": 999,
+			"minSelect": 0,
+			"name": "numbers",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "relation"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(11, []byte(`{
+			"hidden": false,
+			"id": "json3360715148",
+			"maxSize": 0,
+			"name": "numberTag",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "json"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("_pb_users_auth_")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("relation2710458623")
+
+		// remove field
+		collection.Fields.RemoveById("json3360715148")
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"github.com/tcmzzz/lightcall/server/config"
+	"github.com/tcmzzz/lightcall/server/phone"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("zrgaj6lwf40ux11")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(5, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text1720358416",
+			"max": 0,
+			"min": 0,
+			"name": "calleeE164",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(6, []byte(`{
+			"hidden": false,
+			"id": "bool3528610941",
+			"name": "calleeValid",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "bool"
+		}`)); err != nil {
+			return err
+		}
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		return backfillCalleeE164(app)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("zrgaj6lwf40ux11")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("text1720358416")
+
+		// remove field
+		collection.Fields.RemoveById("bool3528610941")
+
+		return app.Save(collection)
+	})
+}
+
+// backfillCalleeE164 normalizes the callee of the existing tasks as the task hook does on save,
+// by the default country of the phone config, phone.DefaultCountry when it is not set yet.
+func backfillCalleeE164(app core.App) error {
+	country := ""
+	if p, err := config.New(app).Phone(); err == nil {
+		country = p.DefaultCountry
+	}
+
+	tasks := []struct {
+		ID     string `db:"id"`
+		Callee string `db:"callee"`
+	}{}
+	if err := app.DB().Select("id", "callee").From("task").All(&tasks); err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		e164, err := phone.Normalize(task.Callee, country)
+		valid := err == nil
+		if _, err := app.DB().Update("task", dbx.Params{
+			"calleeE164":  e164,
+			"calleeValid": valid,
+		}, dbx.HashExp{"id": task.ID}).Execute(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package app
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"createRule": null,
+			"deleteRule": null,
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "text3208210256",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"cascadeDelete": false,
+					"collectionId": "goc7ifjp3rggn01",
+					"hidden": false,
+					"id": "relation2134807182",
+					"maxSelect": 1,
+					"minSelect": 0,
+					"name": "activity",
+					"presentable": false,
+					"required": true,
+					"system": false,
+					"type": "relation"
+				},
+				{
+					"cascadeDelete": false,
+					"collectionId": "_pb_users_auth_",
+					"hidden": false,
+					"id": "relation2375276105",
+					"maxSelect": 1,
+					"minSelect": 0,
+					"name": "user",
+					"presentable": true,
+					"required": true,
+					"system": false,
+					"type": "relation"
+				},
+				{
+					"cascadeDelete": false,
+					"collectionId": "_pb_users_auth_",
+					"hidden": false,
+					"id": "relation3292755334",
+					"maxSelect": 1,
+					"minSelect": 0,
+					"name": "agent",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "relation"
+				},
+				{
+					"hidden": false,
+					"id": "select3616895705",
+					"maxSelect": 1,
+					"name": "mode",
+					"presentable": false,
+					"required": true,
+					"system": false,
+					"type": "select",
+					"values": [
+						"listen",
+						"whisper",
+						"barge"
+					]
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text2491779374",
+					"max": 0,
+					"min": 0,
+					"name": "uuid",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1493228401",
+					"max": 0,
+					"min": 0,
+					"name": "job",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1574812785",
+					"max": 0,
+					"min": 0,
+					"name": "error",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "autodate2990389176",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "autodate3332085495",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_1393851813",
+			"indexes": [
+				"CREATE INDEX ` + "`" + `idx_monitor_activity` + "`" + ` ON ` + "`" + `monitor` + "`" + ` (` + "`" + `activity` + "`" + `)"
+			],
+			"listRule": "@request.auth.isAdmin = true",
+			"name": "monitor",
+			"system": false,
+			"type": "base",
+			"updateRule": null,
+			"viewRule": "@request.auth.isAdmin = true"
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1393851813")
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
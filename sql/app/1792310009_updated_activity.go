@@ -0,0 +1,111 @@
+package app
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("goc7ifjp3rggn01")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(6, []byte(`{
+			"hidden": false,
+			"id": "select2744374011",
+			"maxSelect": 1,
+			"name": "state",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "select",
+			"values": [
+				"created",
+				"dialing",
+				"ringing",
+				"answered",
+				"ended",
+				"failed",
+				"abandoned"
+			]
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(7, []byte(`{
+			"cascadeDelete": false,
+			"collectionId": "zrgaj6lwf40ux11",
+			"hidden": false,
+			"id": "relation1384045349",
+			"maxSelect": 1,
+			"minSelect": 0,
+			"name": "task",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "relation"
+		}`)); err != nil {
+			return err
+		}
+
+		collection.AddIndex("idx_activity_state", false, "`state`, `created`", "")
+		collection.AddIndex("idx_activity_task", false, "`task`", "")
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// the task of the activities already related to one
+		if _, err := app.DB().NewQuery(`
+			UPDATE activity SET task = COALESCE((
+				SELECT task.id FROM task, json_each(task.activity) AS je
+				WHERE je.value = activity.id LIMIT 1
+			), '') WHERE task = ''`).Execute(); err != nil {
+			return err
+		}
+
+		// the calls already over, by their cdr or failure
+		_, err = app.DB().NewQuery(`
+			UPDATE activity SET state = CASE
+				WHEN json_extract(rawlog, '$.state.connect_ok') = 1 THEN 'ended'
+				ELSE 'failed'
+			END
+			WHERE isCall = 1 AND state = '' AND json_valid(rawlog) AND (
+				json_extract(rawlog, '$.fslega') IS NOT NULL OR json_extract(rawlog, '$.fail') IS NOT NULL
+			)`).Execute()
+		if err != nil {
+			return err
+		}
+
+		// the calls still without a cdr: those of the last day are left to the reaper, dialed once
+		// FreeSWITCH saved their attempts, the older ones are abandoned here without an event
+		_, err = app.DB().NewQuery(`
+			UPDATE activity SET state = CASE
+				WHEN created < strftime('%Y-%m-%d %H:%M:%fZ', 'now', '-1 day') THEN 'abandoned'
+				WHEN json_valid(rawlog) AND json_extract(rawlog, '$.attempts') IS NOT NULL THEN 'dialing'
+				ELSE 'created'
+			END
+			WHERE isCall = 1 AND state = ''`).Execute()
+		return err
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("goc7ifjp3rggn01")
+		if err != nil {
+			return err
+		}
+
+		collection.RemoveIndex("idx_activity_state")
+		collection.RemoveIndex("idx_activity_task")
+
+		// remove field
+		collection.Fields.RemoveById("select2744374011")
+
+		// remove field
+		collection.Fields.RemoveById("relation1384045349")
+
+		return app.Save(collection)
+	})
+}